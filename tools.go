@@ -159,7 +159,7 @@ func (t *Tools) Slugify(s string) (string, error) {
 
 func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, p, file, displayName string) {
 	fp := path.Join(p, file)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename\"%s\"", displayName))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayName))
 	http.ServeFile(w, r, fp)
 }
 
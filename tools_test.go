@@ -219,7 +219,7 @@ func TestTools_DownloadStaticFile(t *testing.T) {
 		t.Error("Expected the soze to be ", res.Header["Content-Length"][0])
 	}
 	fmt.Println(res.Header["Content-Disposition"][0])
-	if res.Header["Content-Disposition"][0] != "attachment; filename\"love.jpg\"" {
+	if res.Header["Content-Disposition"][0] != "attachment; filename=\"love.jpg\"" {
 		t.Error("wrong content-disposition")
 	}
 }
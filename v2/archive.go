@@ -0,0 +1,173 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ArchiveFormat selects the kind of archive DownloadArchive writes.
+type ArchiveFormat int
+
+const (
+	ArchiveZip ArchiveFormat = iota
+	ArchiveTarGz
+)
+
+// ArchiveEntry is a stored file to include in an archive.
+type ArchiveEntry struct {
+	Key string
+	// Name is the path of the file inside the archive. It defaults to the
+	// base of Key.
+	Name string
+}
+
+// DownloadArchive streams the stored files in entries to w as a single zip or
+// tar.gz archive named archiveName, without buffering them. Every entry is
+// checked before anything is written, so a missing file still gets a 404.
+// Once streaming has started a failure, including the client going away,
+// can only cut the archive short; it is logged and the archive is left
+// unfinished so clients see it as corrupt.
+func (t *Tools) DownloadArchive(w http.ResponseWriter, r *http.Request, format ArchiveFormat, archiveName string, entries []ArchiveEntry) {
+	ctx := r.Context()
+	storage := t.storage()
+	infos := make([]*ObjectInfo, len(entries))
+	for i, e := range entries {
+		info, err := storage.Stat(ctx, e.Key)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				http.NotFound(w, r)
+				return
+			}
+			t.logger().Error("archive download failed", append(requestAttrs(r), "key", e.Key, "error", err)...)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		infos[i] = info
+	}
+
+	contentType, ext := "application/zip", ".zip"
+	if format == ArchiveTarGz {
+		contentType, ext = "application/gzip", ".tar.gz"
+	}
+	if archiveName == "" {
+		archiveName = "download" + ext
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archiveName))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var err error
+	if format == ArchiveTarGz {
+		err = t.writeTarGz(ctx, w, entries, infos)
+	} else {
+		err = t.writeZip(ctx, w, entries, infos)
+	}
+	if err != nil {
+		t.logger().Error("archive download failed", append(requestAttrs(r), "error", err)...)
+	}
+}
+
+func (t *Tools) writeZip(ctx context.Context, w io.Writer, entries []ArchiveEntry, infos []*ObjectInfo) error {
+	zw := zip.NewWriter(w)
+	names := archiveNames(entries)
+	for i, e := range entries {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: names[i], Method: zip.Deflate, Modified: infos[i].ModTime})
+		if err != nil {
+			return err
+		}
+		if err := t.copyEntry(ctx, fw, e.Key); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (t *Tools) writeTarGz(ctx context.Context, w io.Writer, entries []ArchiveEntry, infos []*ObjectInfo) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	names := archiveNames(entries)
+	for i, e := range entries {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     names[i],
+			Mode:     0644,
+			Size:     infos[i].Size,
+			ModTime:  infos[i].ModTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		// tar needs the size up front; a file that changed since Stat makes
+		// the copy or the next header fail
+		if err := t.copyEntry(ctx, tw, e.Key); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// copyEntry copies the object stored under key to w, stopping when ctx is
+// done.
+func (t *Tools) copyEntry(ctx context.Context, w io.Writer, key string) error {
+	rc, _, err := t.storage().Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, &contextReader{ctx: ctx, r: rc})
+	return err
+}
+
+// contextReader fails reads once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// archiveNames returns a relative, clean and unique name inside the archive
+// for every entry, so extracting it cannot write outside the target
+// directory.
+func archiveNames(entries []ArchiveEntry) []string {
+	names := make([]string, len(entries))
+	seen := make(map[string]bool)
+	for i, e := range entries {
+		name := e.Name
+		if name == "" {
+			name = path.Base(e.Key)
+		}
+		name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+		if name == "" {
+			name = "file"
+		}
+		unique := name
+		ext := path.Ext(name)
+		for n := 1; seen[unique]; n++ {
+			unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		seen[unique] = true
+		names[i] = unique
+	}
+	return names
+}
@@ -0,0 +1,138 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newArchiveStorage() *MemoryStorage {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+	storage.Put(ctx, "uploads/x1.txt", strings.NewReader("first"), "text/plain")
+	storage.Put(ctx, "uploads/x2.txt", strings.NewReader("second"), "text/plain")
+	storage.Put(ctx, "uploads/x3.txt", strings.NewReader("third"), "text/plain")
+	return storage
+}
+
+var archiveEntries = []ArchiveEntry{
+	{Key: "uploads/x1.txt", Name: "notes.txt"},
+	{Key: "uploads/x2.txt", Name: "notes.txt"},
+	{Key: "uploads/x3.txt", Name: "../../etc/cron.d/evil"},
+}
+
+var expectedArchive = map[string]string{
+	"notes.txt":       "first",
+	"notes-1.txt":     "second",
+	"etc/cron.d/evil": "third",
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
+
+var archiveTests = []struct {
+	name                string
+	format              ArchiveFormat
+	archiveName         string
+	read                func(*testing.T, []byte) map[string]string
+	expectedType        string
+	expectedDisposition string
+}{
+	{name: "zip", format: ArchiveZip, read: readZip, expectedType: "application/zip", expectedDisposition: `attachment; filename="download.zip"`},
+	{name: "tar.gz", format: ArchiveTarGz, archiveName: "all files.tar.gz", read: readTarGz, expectedType: "application/gzip", expectedDisposition: `attachment; filename="all files.tar.gz"`},
+}
+
+func TestTools_DownloadArchive(t *testing.T) {
+	testTools := Tools{Storage: newArchiveStorage()}
+	for _, e := range archiveTests {
+		rr := httptest.NewRecorder()
+		testTools.DownloadArchive(rr, httptest.NewRequest("GET", "/", nil), e.format, e.archiveName, archiveEntries)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status %d", e.name, rr.Code)
+			continue
+		}
+		if rr.Header().Get("Content-Type") != e.expectedType || rr.Header().Get("Content-Disposition") != e.expectedDisposition {
+			t.Errorf("%s: unexpected headers %v", e.name, rr.Header())
+		}
+		files := e.read(t, rr.Body.Bytes())
+		if len(files) != len(expectedArchive) {
+			t.Errorf("%s: expected %d files but got %v", e.name, len(expectedArchive), files)
+		}
+		for name, content := range expectedArchive {
+			if files[name] != content {
+				t.Errorf("%s: expected %q to hold %q but got %q", e.name, name, content, files[name])
+			}
+		}
+	}
+}
+
+func TestTools_DownloadArchiveMissing(t *testing.T) {
+	testTools := Tools{Storage: newArchiveStorage()}
+	rr := httptest.NewRecorder()
+	entries := append([]ArchiveEntry{{Key: "uploads/missing.txt"}}, archiveEntries...)
+	testTools.DownloadArchive(rr, httptest.NewRequest("GET", "/", nil), ArchiveZip, "", entries)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 but got %d", rr.Code)
+	}
+}
+
+func TestTools_DownloadArchiveCanceled(t *testing.T) {
+	logger := &testLogger{}
+	testTools := Tools{Storage: newArchiveStorage(), Logger: logger}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	testTools.DownloadArchive(rr, req, ArchiveZip, "", archiveEntries)
+	if _, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len())); err == nil {
+		t.Error("expected an unfinished archive")
+	}
+	failed := logger.find("archive download failed")
+	if len(failed) != 1 {
+		t.Fatalf("expected the failure to be logged once but got %d records", len(failed))
+	}
+	if err, _ := failed[0].attrs["error"].(error); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation error but got %v", failed[0].attrs["error"])
+	}
+}
@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// AuthProvider adds credentials to an outgoing request.
+type AuthProvider interface {
+	Authorize(r *http.Request) error
+}
+
+// AuthFunc adapts a function to an AuthProvider.
+type AuthFunc func(r *http.Request) error
+
+func (f AuthFunc) Authorize(r *http.Request) error {
+	return f(r)
+}
+
+// BearerToken authorizes requests with a static bearer token.
+func BearerToken(token string) AuthProvider {
+	return AuthFunc(func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// Client calls a JSON API below BaseURL. Replies are decoded with the rules
+// ReadJSON uses, taking limits and the logger from Tools, and non-2xx replies
+// are returned as *RemoteError. Use it with Do, Get, Post, Put, Patch and
+// Delete.
+type Client struct {
+	BaseURL    string
+	Header     http.Header
+	Auth       AuthProvider
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+	Tools      *Tools
+}
+
+// NewClient returns a Client for baseURL that shares t's settings.
+func (t *Tools) NewClient(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, Header: make(http.Header), Tools: t}
+}
+
+// Do sends req as JSON with the given method and decodes the reply.
+func Do[Req, Resp any](ctx context.Context, c *Client, method, path string, req Req) (Resp, error) {
+	var resp Resp
+	body, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	err = c.do(ctx, method, path, body, &resp)
+	return resp, err
+}
+
+func Get[Resp any](ctx context.Context, c *Client, path string) (Resp, error) {
+	var resp Resp
+	err := c.do(ctx, http.MethodGet, path, nil, &resp)
+	return resp, err
+}
+
+func Post[Req, Resp any](ctx context.Context, c *Client, path string, req Req) (Resp, error) {
+	return Do[Req, Resp](ctx, c, http.MethodPost, path, req)
+}
+
+func Put[Req, Resp any](ctx context.Context, c *Client, path string, req Req) (Resp, error) {
+	return Do[Req, Resp](ctx, c, http.MethodPut, path, req)
+}
+
+func Patch[Req, Resp any](ctx context.Context, c *Client, path string, req Req) (Resp, error) {
+	return Do[Req, Resp](ctx, c, http.MethodPatch, path, req)
+}
+
+func Delete[Resp any](ctx context.Context, c *Client, path string) (Resp, error) {
+	var resp Resp
+	err := c.do(ctx, http.MethodDelete, path, nil, &resp)
+	return resp, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, target interface{}) error {
+	t := c.Tools
+	if t == nil {
+		t = &Tools{}
+	}
+	opts := []PushOption{WithRetry(c.Retry), WithHeader("Accept", "application/json")}
+	if c.HTTPClient != nil {
+		opts = append(opts, WithClient(c.HTTPClient))
+	}
+	for k, v := range c.Header {
+		for _, value := range v {
+			opts = append(opts, WithHeader(k, value))
+		}
+	}
+	if c.Auth != nil {
+		opts = append(opts, WithAuth(c.Auth))
+	}
+	res, err := t.send(ctx, method, c.url(path), body, opts...)
+	if err != nil {
+		return err
+	}
+	return t.decodeResponse(res, target)
+}
+
+func (c *Client) url(path string) string {
+	if path == "" {
+		return c.BaseURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
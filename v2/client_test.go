@@ -0,0 +1,85 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func newUserServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Tenant") != "acme" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/users/1" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(testUser{ID: 1, Name: "ann"})
+		case r.URL.Path == "/api/users" && r.Method == http.MethodPost,
+			r.URL.Path == "/api/users/1" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+			var u testUser
+			if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			u.ID = 1
+			json.NewEncoder(w).Encode(u)
+		case r.URL.Path == "/api/users/1" && r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	srv := newUserServer(t)
+	defer srv.Close()
+	var testTools Tools
+	c := testTools.NewClient(srv.URL + "/api/")
+	c.Auth = BearerToken("secret")
+	c.Header.Set("X-Tenant", "acme")
+	ctx := context.Background()
+
+	u, err := Get[testUser](ctx, c, "/users/1")
+	if err != nil || u.Name != "ann" {
+		t.Errorf("get: %v %+v", err, u)
+	}
+	u, err = Post[testUser, testUser](ctx, c, "users", testUser{Name: "bob"})
+	if err != nil || u.ID != 1 || u.Name != "bob" {
+		t.Errorf("post: %v %+v", err, u)
+	}
+	u, err = Put[testUser, testUser](ctx, c, "users/1", testUser{Name: "carl"})
+	if err != nil || u.Name != "carl" {
+		t.Errorf("put: %v %+v", err, u)
+	}
+	u, err = Patch[map[string]string, testUser](ctx, c, "users/1", map[string]string{"name": "dora"})
+	if err != nil || u.Name != "dora" {
+		t.Errorf("patch: %v %+v", err, u)
+	}
+	if _, err = Delete[struct{}](ctx, c, "users/1"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+
+	_, err = Get[testUser](ctx, c, "users/2")
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 remote error but got %v", err)
+	}
+
+	c.Auth = AuthFunc(func(r *http.Request) error { return errors.New("no token") })
+	if _, err = Get[testUser](ctx, c, "users/1"); err == nil || err.Error() != "no token" {
+		t.Errorf("expected auth error but got %v", err)
+	}
+}
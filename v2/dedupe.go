@@ -0,0 +1,65 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// putDeduplicated stores r under uploadDir named after the SHA-256 of its
+// content. The content is written to a temporary key first because the
+// digest is only known once it has all been read.
+func (t *Tools) putDeduplicated(ctx context.Context, uploadedFile *UploadedFile, uploadDir, ext string, r io.Reader, contentType string) (*ObjectInfo, error) {
+	storage := t.storage()
+	dir := filepath.ToSlash(uploadDir)
+	hash := sha256.New()
+	tmpKey := path.Join(dir, fmt.Sprintf(".tmp-%s", t.RandomString(25)))
+	info, err := storage.Put(ctx, tmpKey, io.TeeReader(r, hash), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadedFile.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	uploadedFile.NewFileName = uploadedFile.SHA256 + strings.ToLower(ext)
+	key := path.Join(dir, uploadedFile.NewFileName)
+	existing, err := storage.Stat(ctx, key)
+	if err == nil {
+		storage.Delete(ctx, tmpKey)
+		uploadedFile.Duplicate = true
+		return existing, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		storage.Delete(ctx, tmpKey)
+		return nil, err
+	}
+	if err := t.move(ctx, tmpKey, key, contentType); err != nil {
+		storage.Delete(ctx, tmpKey)
+		return nil, err
+	}
+	info.Key = key
+	return info, nil
+}
+
+// move renames from to to, copying the object when the storage can't rename.
+func (t *Tools) move(ctx context.Context, from, to, contentType string) error {
+	storage := t.storage()
+	if renamer, ok := storage.(Renamer); ok {
+		return renamer.Rename(ctx, from, to)
+	}
+	rc, _, err := storage.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if _, err := storage.Put(ctx, to, rc, contentType); err != nil {
+		return err
+	}
+	return storage.Delete(ctx, from)
+}
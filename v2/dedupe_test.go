@@ -0,0 +1,129 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+var dedupeTests = []struct {
+	name    string
+	storage Storage
+}{
+	{name: "memory", storage: NewMemoryStorage()},
+	{name: "disk", storage: &DiskStorage{Root: "./testdata/dedupe"}},
+}
+
+func TestTools_UploadDeduplicated(t *testing.T) {
+	defer os.RemoveAll("./testdata/dedupe")
+	sum := sha256.Sum256([]byte("hello from a.txt"))
+	digest := hex.EncodeToString(sum[:])
+
+	for _, e := range dedupeTests {
+		testTools := Tools{Storage: e.storage, Deduplicate: true, StreamUploads: true}
+		var keys []string
+		for i, expectDuplicate := range []bool{false, true} {
+			uploadedFile, err := testTools.UploadOneFile(newUploadRequest(t, "a.txt"), "uploads", false)
+			if err != nil {
+				t.Fatalf("%s: %s", e.name, err)
+			}
+			if uploadedFile.SHA256 != digest || uploadedFile.NewFileName != digest+".txt" {
+				t.Errorf("%s: upload %d: unexpected file %+v", e.name, i, uploadedFile)
+			}
+			if uploadedFile.Duplicate != expectDuplicate {
+				t.Errorf("%s: upload %d: expected duplicate to be %v", e.name, i, expectDuplicate)
+			}
+			keys = append(keys, uploadedFile.Key)
+		}
+		if keys[0] != keys[1] {
+			t.Errorf("%s: expected both uploads to share a key but got %v", e.name, keys)
+		}
+		objects, _ := e.storage.List(context.Background(), "uploads/")
+		if len(objects) != 1 {
+			t.Errorf("%s: expected 1 stored object but got %d", e.name, len(objects))
+		}
+	}
+}
+
+func TestTools_UploadDeduplicatedRollback(t *testing.T) {
+	storage := NewMemoryStorage()
+	testTools := Tools{Storage: storage, Deduplicate: true, StreamUploads: true}
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, "a.txt"), "uploads"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rolled back batch must not remove content stored by an earlier upload
+	testTools.AllOrNothing = true
+	testTools.AllowedFileType = []string{"text/plain; charset=utf-8"}
+	req := newUploadRequest(t, "a.txt", "b.png")
+	if _, err := testTools.UploadFilesWithResults(req, "uploads"); err != nil {
+		t.Fatal(err)
+	}
+	objects, _ := storage.List(context.Background(), "uploads/")
+	if len(objects) != 1 {
+		t.Errorf("expected the earlier upload to survive the rollback but found %d objects", len(objects))
+	}
+}
+
+func TestTools_UploadDeduplicatedConcurrentRollback(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	testTools := Tools{
+		Storage:         storage,
+		Deduplicate:     true,
+		StreamUploads:   true,
+		AllOrNothing:    true,
+		AllowedFileType: []string{"text/plain; charset=utf-8"},
+	}
+	sum := sha256.Sum256([]byte("hello from a.txt"))
+	key := "uploads/" + hex.EncodeToString(sum[:]) + ".txt"
+
+	// upload A stores a.txt and is then rolled back for a rejected part,
+	// while upload B of the same content finishes in between
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	reqA := httptest.NewRequest(http.MethodPost, "/", pr)
+	reqA.Header.Set("Content-Type", writer.FormDataContentType())
+	done := make(chan error)
+	go func() {
+		_, err := testTools.UploadFiles(reqA, "uploads")
+		done <- err
+	}()
+	part, _ := writer.CreateFormFile("file", "a.txt")
+	io.WriteString(part, "hello from a.txt")
+	part, _ = writer.CreateFormFile("file", "b.png")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := storage.Stat(ctx, key); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("upload A did not store its first file")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	uploadedFile, err := testTools.UploadOneFile(newUploadRequest(t, "a.txt"), "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !uploadedFile.Duplicate {
+		t.Error("expected upload B to be a duplicate")
+	}
+
+	part.Write(newTestPNG(t, 8, 8, "x"))
+	writer.Close()
+	pw.Close()
+	if err := <-done; err == nil {
+		t.Error("expected upload A to be rolled back")
+	}
+	if _, err := storage.Stat(ctx, uploadedFile.Key); err != nil {
+		t.Errorf("expected the content of upload B to survive the rollback: %s", err)
+	}
+}
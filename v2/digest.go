@@ -0,0 +1,90 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// digestCheck is a digest a client sent for a part.
+type digestCheck struct {
+	header    string
+	algorithm string
+	hash      hash.Hash
+	expected  []byte
+}
+
+// partDigests collects the digests sent in the Content-MD5, Digest,
+// Content-Digest and Repr-Digest headers of a part. Algorithms other than
+// MD5 and SHA-256 are ignored.
+func partDigests(header textproto.MIMEHeader) ([]*digestCheck, error) {
+	var checks []*digestCheck
+	if v := header.Get("Content-MD5"); v != "" {
+		check, err := newDigestCheck("Content-MD5", "md5", v)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	for _, name := range []string{"Digest", "Content-Digest", "Repr-Digest"} {
+		for _, v := range header.Values(name) {
+			for _, member := range strings.Split(v, ",") {
+				algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+				algorithm = strings.ToLower(algorithm)
+				if !ok || algorithm != "md5" && algorithm != "sha-256" {
+					continue
+				}
+				// Content-Digest and Repr-Digest wrap the value in colons
+				value = strings.Trim(value, ":")
+				check, err := newDigestCheck(name, algorithm, value)
+				if err != nil {
+					return nil, err
+				}
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks, nil
+}
+
+func newDigestCheck(header, algorithm, value string) (*digestCheck, error) {
+	expected, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, &detailedError{ErrDigestMismatch, fmt.Sprintf("%s header holds an invalid %s digest", header, algorithm)}
+	}
+	check := &digestCheck{header: header, algorithm: algorithm, expected: expected}
+	if algorithm == "md5" {
+		check.hash = md5.New()
+	} else {
+		check.hash = sha256.New()
+	}
+	return check, nil
+}
+
+// digestReader hashes everything read through it and fails at the end of
+// the content if any digest does not match.
+type digestReader struct {
+	r      io.Reader
+	checks []*digestCheck
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	for _, check := range d.checks {
+		check.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		for _, check := range d.checks {
+			if !bytes.Equal(check.hash.Sum(nil), check.expected) {
+				return n, &detailedError{ErrDigestMismatch, fmt.Sprintf("content does not match the %s digest in the %s header", check.algorithm, check.header)}
+			}
+		}
+	}
+	return n, err
+}
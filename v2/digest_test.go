@@ -0,0 +1,83 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+var digestTests = []struct {
+	name          string
+	header        string
+	value         string
+	errorExpected bool
+}{
+	{name: "no digest", errorExpected: false},
+	{name: "content-md5", header: "Content-MD5", value: md5Base64("hello"), errorExpected: false},
+	{name: "content-md5 mismatch", header: "Content-MD5", value: md5Base64("hullo"), errorExpected: true},
+	{name: "digest sha-256", header: "Digest", value: "SHA-256=" + sha256Base64("hello"), errorExpected: false},
+	{name: "digest md5 and sha-256", header: "Digest", value: "md5=" + md5Base64("hello") + ", sha-256=" + sha256Base64("hello"), errorExpected: false},
+	{name: "digest one mismatch", header: "Digest", value: "md5=" + md5Base64("hello") + ", sha-256=" + sha256Base64("hullo"), errorExpected: true},
+	{name: "digest unknown algorithm", header: "Digest", value: "sha-512=abc", errorExpected: false},
+	{name: "repr-digest", header: "Repr-Digest", value: "sha-256=:" + sha256Base64("hello") + ":", errorExpected: false},
+	{name: "repr-digest mismatch", header: "Repr-Digest", value: "sha-256=:" + sha256Base64("hullo") + ":", errorExpected: true},
+	{name: "invalid base64", header: "Content-MD5", value: "not base64!", errorExpected: true},
+}
+
+func TestTools_UploadDigest(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		for _, e := range digestTests {
+			storage := NewMemoryStorage()
+			testTools := Tools{Storage: storage, StreamUploads: stream}
+			header := make(textproto.MIMEHeader)
+			if e.header != "" {
+				header.Set(e.header, e.value)
+			}
+			_, err := testTools.UploadOneFile(newDigestRequest(t, header, "hello"), "uploads")
+			if e.errorExpected && !errors.Is(err, ErrDigestMismatch) {
+				t.Errorf("%s: expected digest mismatch but got %v", e.name, err)
+			}
+			if !e.errorExpected && err != nil {
+				t.Errorf("%s: %s", e.name, err)
+			}
+			objects, _ := storage.List(context.Background(), "uploads/")
+			if e.errorExpected && len(objects) != 0 {
+				t.Errorf("%s: expected no stored object but found %d", e.name, len(objects))
+			}
+		}
+	}
+}
+
+func newDigestRequest(t *testing.T, header textproto.MIMEHeader, content string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="a.txt"`)
+	header.Set("Content-Type", "text/plain")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+	request := httptest.NewRequest("POST", "/", body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func md5Base64(s string) string {
+	sum := md5.Sum([]byte(s))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func sha256Base64(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,161 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DownloadOption changes how a single download is served.
+type DownloadOption func(*downloadOptions)
+
+type downloadOptions struct {
+	disposition string
+}
+
+// Inline asks the browser to display the file instead of saving it.
+func Inline() DownloadOption {
+	return func(o *downloadOptions) {
+		o.disposition = "inline"
+	}
+}
+
+func newDownloadOptions(opts []DownloadOption) downloadOptions {
+	o := downloadOptions{disposition: "attachment"}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// DownloadConfinedFile serves the file name, a slash separated path relative
+// to root, as an attachment. Names that are not clean relative paths, and
+// names that resolve through symlinks to somewhere outside root, get a plain
+// 404 so the response does not reveal what exists on disk. The name is
+// resolved again once the file is open, so a symlink swapped in between is
+// caught too, though this is not airtight; see checkConfined. An empty
+// displayName uses the base of name.
+func (t *Tools) DownloadConfinedFile(w http.ResponseWriter, r *http.Request, root, name, displayName string, opts ...DownloadOption) {
+	o := newDownloadOptions(opts)
+	fp, err := confinedPath(root, name)
+	if err != nil {
+		t.logger().Warn("download rejected", append(requestAttrs(r), "name", name, "error", err)...)
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.logger().Error("download failed", append(requestAttrs(r), "name", name, "error", err)...)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if err := checkConfined(root, name, fi); err != nil {
+		t.logger().Warn("download rejected", append(requestAttrs(r), "name", name, "error", err)...)
+		http.NotFound(w, r)
+		return
+	}
+	if displayName == "" {
+		displayName = path.Base(name)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(o.disposition, displayName))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// errOutsideRoot is returned by confinedPath for names that escape the root.
+var errOutsideRoot = errors.New("path escapes the download root")
+
+// confinedPath resolves name below root, following symlinks, and fails unless
+// the result is still inside root. The result is only checked, not held, so
+// whoever can write below root may swap a symlink before it is opened; see
+// checkConfined.
+func confinedPath(root, name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." || strings.ContainsRune(name, '\\') {
+		return "", errOutsideRoot
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realRoot, err = filepath.Abs(realRoot)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(realRoot, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realRoot, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return real, nil
+}
+
+// checkConfined resolves name again once it is open and fails unless it still
+// leads to opened, the file info of what was served. This catches a symlink
+// swapped in between resolving and opening name. A swap timed to race this
+// check as well, or a hard link to a file outside root, is not caught;
+// confinement against hostile writers below root needs the operating
+// system's help, such as os.Root in newer versions of Go.
+func checkConfined(root, name string, opened fs.FileInfo) error {
+	fp, err := confinedPath(root, name)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(fp)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(opened, fi) {
+		return errOutsideRoot
+	}
+	return nil
+}
+
+// contentDisposition builds a Content-Disposition header as described in RFC
+// 6266. The quoted filename parameter holds an ASCII fallback; names that need
+// more than that are also sent percent encoded in filename* (RFC 5987).
+func contentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, filename)
+	header := fmt.Sprintf("%s; filename=\"%s\"", disposition, fallback)
+	if fallback != filename {
+		header += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return header
+}
+
+// encodeRFC5987 percent encodes every byte of s that is not an attr-char.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
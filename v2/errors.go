@@ -0,0 +1,137 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrFileTooLarge       = errors.New("Uploaded file size if too big")
+	ErrFileTypeNotAllowed = errors.New("this file type is not permitted")
+	// ErrUploadRolledBack is reported for files that were saved and then
+	// removed again because another file in an AllOrNothing batch failed.
+	ErrUploadRolledBack = errors.New("upload removed because another file in the batch failed")
+	ErrBodyTooLarge     = errors.New("body too large")
+	ErrEmptySlug        = errors.New("slug is empty")
+	// ErrDigestMismatch means an uploaded file does not match the digest
+	// the client sent for it.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrInvalidFileName is returned when an uploaded file name has nothing
+	// left after sanitising, or would be stored outside the upload directory.
+	ErrInvalidFileName = errors.New("invalid file name")
+	// ErrFileExists is returned when an upload would replace an existing file
+	// and FileNameCollision is CollisionError.
+	ErrFileExists = errors.New("file already exists")
+	// ErrQuotaExceeded is returned when an upload would take an identity
+	// over its Quota.
+	ErrQuotaExceeded = errors.New("upload quota exceeded")
+	// ErrFileTypeMismatch is returned when the extension or Content-Type of
+	// an uploaded file does not match its content and TypeMismatch is
+	// TypeMismatchReject.
+	ErrFileTypeMismatch = errors.New("file type does not match its content")
+	// ErrImageTooLarge is returned for images over the dimensions allowed by
+	// ImagePolicy, and ErrInvalidImage for images that cannot be read.
+	ErrImageTooLarge = errors.New("image dimensions too large")
+	ErrInvalidImage  = errors.New("invalid image")
+)
+
+// detailedError carries a more specific message than the sentinel it wraps.
+type detailedError struct {
+	err error
+	msg string
+}
+
+func (e *detailedError) Error() string { return e.msg }
+
+func (e *detailedError) Unwrap() error { return e.err }
+
+type JSONErrorKind int
+
+const (
+	JSONSyntax JSONErrorKind = iota + 1
+	JSONType
+	JSONUnknownField
+	JSONEmptyBody
+	JSONMultipleValues
+	JSONInvalidTarget
+)
+
+var jsonErrorKinds = map[JSONErrorKind]string{
+	JSONSyntax:         "syntax",
+	JSONType:           "type",
+	JSONUnknownField:   "unknown_field",
+	JSONEmptyBody:      "empty_body",
+	JSONMultipleValues: "multiple_values",
+	JSONInvalidTarget:  "invalid_target",
+}
+
+func (k JSONErrorKind) String() string {
+	return jsonErrorKinds[k]
+}
+
+// JSONDecodeError describes why a JSON body could not be decoded. Field and
+// Offset are set when the decoder reported them.
+type JSONDecodeError struct {
+	Kind   JSONErrorKind
+	Field  string
+	Offset int64
+	Err    error
+}
+
+func (e *JSONDecodeError) Error() string {
+	switch e.Kind {
+	case JSONSyntax:
+		if e.Offset > 0 {
+			return fmt.Sprintf("body contains badly formatted json (at char %d)", e.Offset)
+		}
+		return "body contains badly formatted json"
+	case JSONType:
+		if e.Field != "" {
+			return fmt.Sprintf("body contains incorrect json type for field %q", e.Field)
+		}
+		return fmt.Sprintf("body contains incorrect json type (at char %d)", e.Offset)
+	case JSONUnknownField:
+		return fmt.Sprintf("body contains unknown key %q", e.Field)
+	case JSONEmptyBody:
+		return "body must not be empty"
+	case JSONMultipleValues:
+		return "body must contain only one JSON value"
+	default:
+		return fmt.Sprintf("error unmarshalling json %v", e.Err)
+	}
+}
+
+func (e *JSONDecodeError) Unwrap() error { return e.Err }
+
+// ErrorStatus returns the HTTP status code that best describes err. Errors
+// toolkit does not know about are reported as 400 Bad Request.
+func ErrorStatus(err error) int {
+	var jsonErr *JSONDecodeError
+	var remoteErr *RemoteError
+	switch {
+	case errors.As(err, &remoteErr):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrBodyTooLarge), errors.Is(err, ErrImageTooLarge),
+		errors.Is(err, ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileTypeNotAllowed), errors.Is(err, ErrFileTypeMismatch):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrFileExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrSignatureExpired):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrInvalidURLSignature), errors.Is(err, ErrURLExpired):
+		return http.StatusForbidden
+	case errors.As(err, &jsonErr):
+		switch jsonErr.Kind {
+		case JSONType, JSONUnknownField:
+			return http.StatusUnprocessableEntity
+		case JSONInvalidTarget:
+			return http.StatusInternalServerError
+		}
+	case errors.Is(err, ErrMissingSecret):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
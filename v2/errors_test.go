@@ -0,0 +1,82 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var readJSONErrorTests = []struct {
+	name  string
+	json  string
+	kind  JSONErrorKind
+	field string
+}{
+	{name: "syntax", json: `{"foo":}`, kind: JSONSyntax},
+	{name: "truncated", json: `{"foo":"bar"`, kind: JSONSyntax},
+	{name: "type", json: `{"foo":1}`, kind: JSONType, field: "foo"},
+	{name: "unknown field", json: `{"fooo":"bar"}`, kind: JSONUnknownField, field: "fooo"},
+	{name: "empty", json: ``, kind: JSONEmptyBody},
+	{name: "multiple values", json: `{"foo":"bar"}{}`, kind: JSONMultipleValues},
+}
+
+func TestJSONDecodeError(t *testing.T) {
+	var testTools Tools
+	for _, e := range readJSONErrorTests {
+		var decodedJSON struct {
+			Foo string `json:"foo"`
+		}
+		req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+		var jsonErr *JSONDecodeError
+		if !errors.As(err, &jsonErr) {
+			t.Errorf("%s: expected *JSONDecodeError but got %v", e.name, err)
+			continue
+		}
+		if jsonErr.Kind != e.kind || jsonErr.Field != e.field {
+			t.Errorf("%s: unexpected error %+v", e.name, jsonErr)
+		}
+	}
+}
+
+var errorStatusTests = []struct {
+	name   string
+	err    error
+	status int
+}{
+	{name: "file too large", err: ErrFileTooLarge, status: http.StatusRequestEntityTooLarge},
+	{name: "body too large", err: &detailedError{ErrBodyTooLarge, "body must not be larger than 5 bytes"}, status: http.StatusRequestEntityTooLarge},
+	{name: "file type", err: ErrFileTypeNotAllowed, status: http.StatusUnsupportedMediaType},
+	{name: "file exists", err: ErrFileExists, status: http.StatusConflict},
+	{name: "quota exceeded", err: ErrQuotaExceeded, status: http.StatusRequestEntityTooLarge},
+	{name: "image too large", err: &detailedError{ErrImageTooLarge, "image has 2500000000 pixels"}, status: http.StatusRequestEntityTooLarge},
+	{name: "missing secret", err: ErrMissingSecret, status: http.StatusInternalServerError},
+	{name: "json type", err: &JSONDecodeError{Kind: JSONType, Field: "foo"}, status: http.StatusUnprocessableEntity},
+	{name: "json syntax", err: &JSONDecodeError{Kind: JSONSyntax}, status: http.StatusBadRequest},
+	{name: "unknown", err: errors.New("some error"), status: http.StatusBadRequest},
+}
+
+func TestErrorStatus(t *testing.T) {
+	var testTools Tools
+	for _, e := range errorStatusTests {
+		if status := ErrorStatus(e.err); status != e.status {
+			t.Errorf("%s: expected %d but got %d", e.name, e.status, status)
+		}
+		rr := httptest.NewRecorder()
+		testTools.ErrorJson(rr, e.err)
+		if rr.Code != e.status {
+			t.Errorf("%s: ErrorJson wrote %d instead of %d", e.name, rr.Code, e.status)
+		}
+	}
+}
+
+func TestTools_SlugifyError(t *testing.T) {
+	var testTools Tools
+	for _, s := range []string{"", "こんにちは"} {
+		if _, err := testTools.Slugify(s); !errors.Is(err, ErrEmptySlug) {
+			t.Errorf("%q: expected ErrEmptySlug but got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// CollisionPolicy decides what an upload that keeps its original file name
+// does when a file with that name already exists.
+type CollisionPolicy int
+
+const (
+	// CollisionOverwrite replaces the existing file.
+	CollisionOverwrite CollisionPolicy = iota
+	// CollisionError rejects the upload with ErrFileExists.
+	CollisionError
+	// CollisionSuffix stores the upload as name-1.ext, name-2.ext and so on.
+	CollisionSuffix
+)
+
+// maxFileNameLength is the longest name, in bytes, most file systems accept.
+const maxFileNameLength = 255
+
+// maxCollisionSuffix bounds the search for a free name with CollisionSuffix.
+const maxCollisionSuffix = 1000
+
+// windowsReserved lists device names that cannot be used as file names on
+// Windows, whatever their extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName turns a client supplied file name into one that is safe to
+// store: directories are stripped, the name is NFC normalised, control,
+// formatting and path characters are dropped, leading and trailing dots and
+// spaces are trimmed, Windows device names are prefixed with an underscore and
+// the result is cut to 255 bytes keeping the extension. It fails with
+// ErrInvalidFileName when nothing usable is left.
+func (t *Tools) SanitizeFileName(name string) (string, error) {
+	clean := sanitizeFileName(name)
+	if clean == "" {
+		return "", &detailedError{ErrInvalidFileName, fmt.Sprintf("file name %q has no usable characters", name)}
+	}
+	return clean, nil
+}
+
+func sanitizeFileName(name string) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, ""))
+	// clients on Windows may send full paths with either separator
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFileNameLength/2 {
+			ext = ""
+		}
+		name = truncateUTF8(name[:len(name)-len(ext)], maxFileNameLength-len(ext)) + ext
+	}
+	return name
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// storageKey joins uploadDir and a sanitised file name, checking that the
+// result stays inside uploadDir.
+func storageKey(uploadDir, name string) (string, error) {
+	dir := path.Clean(filepath.ToSlash(uploadDir))
+	key := path.Join(dir, name)
+	if path.Dir(key) != dir {
+		return "", &detailedError{ErrInvalidFileName, fmt.Sprintf("file name %q leaves the upload directory", name)}
+	}
+	return key, nil
+}
+
+// originalFileKey picks the key for an upload that keeps its file name,
+// applying FileNameCollision.
+func (t *Tools) originalFileKey(ctx context.Context, uploadDir, fileName string) (string, string, error) {
+	name, err := t.SanitizeFileName(fileName)
+	if err != nil {
+		return "", "", err
+	}
+	return t.unusedFileKey(ctx, uploadDir, name)
+}
+
+// unusedFileKey returns the key for name in uploadDir, applying
+// FileNameCollision when something is already stored there. With
+// CollisionError and CollisionSuffix the check happens before the file is
+// written, so two uploads racing for the same name can still overwrite each
+// other.
+func (t *Tools) unusedFileKey(ctx context.Context, uploadDir, name string) (string, string, error) {
+	key, err := storageKey(uploadDir, name)
+	if err != nil {
+		return "", "", err
+	}
+	if t.FileNameCollision == CollisionOverwrite {
+		return key, name, nil
+	}
+
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		_, err := t.storage().Stat(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return key, name, nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if t.FileNameCollision == CollisionError {
+			return "", "", &detailedError{ErrFileExists, fmt.Sprintf("a file named %q already exists", name)}
+		}
+		if i > maxCollisionSuffix {
+			return "", "", &detailedError{ErrFileExists, fmt.Sprintf("no free name found for %q", name)}
+		}
+		suffix := fmt.Sprintf("-%d", i)
+		name = truncateUTF8(stem, maxFileNameLength-len(suffix)-len(ext)) + suffix + ext
+		if key, err = storageKey(uploadDir, name); err != nil {
+			return "", "", err
+		}
+	}
+}
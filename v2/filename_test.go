@@ -0,0 +1,107 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	name          string
+	fileName      string
+	expected      string
+	errorExpected bool
+}{
+	{name: "plain", fileName: "report.pdf", expected: "report.pdf"},
+	{name: "unix path", fileName: "../../etc/passwd", expected: "passwd"},
+	{name: "windows path", fileName: `C:\Users\me\..\photo.jpg`, expected: "photo.jpg"},
+	{name: "control characters", fileName: "a\x00b\nc\x7f.txt", expected: "abc.txt"},
+	{name: "bidi override", fileName: "invoice\u202etxt.exe", expected: "invoicetxt.exe"},
+	{name: "windows characters", fileName: `what?<is>"this"|*.txt`, expected: "whatisthis.txt"},
+	{name: "leading dots", fileName: "..htaccess", expected: "htaccess"},
+	{name: "trailing dots and spaces", fileName: "name. . ", expected: "name"},
+	{name: "reserved name", fileName: "con.txt", expected: "_con.txt"},
+	{name: "reserved name without extension", fileName: "LPT1", expected: "_LPT1"},
+	{name: "not reserved", fileName: "console.txt", expected: "console.txt"},
+	{name: "decomposed", fileName: "cafe\u0301.txt", expected: "caf\u00e9.txt"},
+	{name: "too long", fileName: strings.Repeat("é", 200) + ".txt", expected: strings.Repeat("é", 125) + ".txt"},
+	{name: "dot dot", fileName: "..", errorExpected: true},
+	{name: "only separators", fileName: "a/b/", errorExpected: true},
+	{name: "empty", fileName: "", errorExpected: true},
+}
+
+func TestTools_SanitizeFileName(t *testing.T) {
+	var testTools Tools
+	for _, e := range sanitizeTests {
+		got, err := testTools.SanitizeFileName(e.fileName)
+		if e.errorExpected {
+			if !errors.Is(err, ErrInvalidFileName) {
+				t.Errorf("%s: expected ErrInvalidFileName but got %q, %v", e.name, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+var collisionTests = []struct {
+	name          string
+	policy        CollisionPolicy
+	expected      []string
+	errorExpected bool
+}{
+	{name: "overwrite", policy: CollisionOverwrite, expected: []string{"a.txt", "a.txt", "a.txt"}},
+	{name: "error", policy: CollisionError, expected: []string{"a.txt"}, errorExpected: true},
+	{name: "suffix", policy: CollisionSuffix, expected: []string{"a.txt", "a-1.txt", "a-2.txt"}},
+}
+
+func TestTools_UploadFileNameCollision(t *testing.T) {
+	for _, e := range collisionTests {
+		storage := NewMemoryStorage()
+		testTools := Tools{Storage: storage, FileNameCollision: e.policy}
+		var names []string
+		var err error
+		for i := 0; i < 3 && err == nil; i++ {
+			var uploadedFile *UploadedFile
+			uploadedFile, err = testTools.UploadOneFile(newUploadRequest(t, "a.txt"), "uploads", false)
+			if err == nil {
+				names = append(names, uploadedFile.NewFileName)
+			}
+		}
+		if e.errorExpected != errors.Is(err, ErrFileExists) {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if strings.Join(names, ",") != strings.Join(e.expected, ",") {
+			t.Errorf("%s: expected names %v but got %v", e.name, e.expected, names)
+		}
+	}
+}
+
+func TestTools_UploadConfined(t *testing.T) {
+	storage := NewMemoryStorage()
+	testTools := Tools{Storage: storage}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", `..\..\evil.txt`)
+	part.Write([]byte("hello"))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	uploadedFile, err := testTools.UploadOneFile(req, "uploads", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadedFile.Key != "uploads/evil.txt" {
+		t.Errorf("expected the file to stay in the upload directory but got key %q", uploadedFile.Key)
+	}
+}
@@ -0,0 +1,121 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+)
+
+// maxBufferedDownload is the size up to which files that cannot seek are
+// buffered in memory. Larger ones are copied to a temporary file.
+const maxBufferedDownload = 8 * 1024 * 1024
+
+// DownloadFSFile serves the file name from fsys, which can be an embed.FS, a
+// zip.Reader or any other fs.FS. Range, If-Modified-Since and ETag
+// preconditions are handled by http.ServeContent. Files without a
+// modification time, such as embedded ones, get an ETag derived from their
+// content; files that cannot seek are buffered first. Invalid and missing
+// names get a 404. An empty displayName uses the base of name.
+func (t *Tools) DownloadFSFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, name, displayName string, opts ...DownloadOption) {
+	o := newDownloadOptions(opts)
+	if !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.logger().Error("download failed", append(requestAttrs(r), "name", name, "error", err)...)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, etag, cleanup, err := seekableContent(f, fi)
+	if err != nil {
+		t.logger().Error("download failed", append(requestAttrs(r), "name", name, "error", err)...)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer cleanup()
+
+	if displayName == "" {
+		displayName = path.Base(name)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(o.disposition, displayName))
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), content)
+}
+
+// seekableContent returns f as an io.ReadSeeker, buffering it when it cannot
+// seek, and an ETag when f has no modification time to validate against.
+// cleanup releases any temporary file.
+func seekableContent(f fs.File, fi fs.FileInfo) (io.ReadSeeker, string, func(), error) {
+	needETag := fi.ModTime().IsZero()
+	if rs, ok := f.(io.ReadSeeker); ok {
+		if !needETag {
+			return rs, "", func() {}, nil
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, rs); err != nil {
+			return nil, "", nil, err
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, "", nil, err
+		}
+		return rs, contentETag(hash.Sum(nil)), func() {}, nil
+	}
+
+	hash := sha256.New()
+	src := io.TeeReader(f, hash)
+	if fi.Size() <= maxBufferedDownload {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return bytes.NewReader(data), optionalETag(needETag, hash.Sum(nil)), func() {}, nil
+	}
+	tmp, err := os.CreateTemp("", "download-*")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	return tmp, optionalETag(needETag, hash.Sum(nil)), cleanup, nil
+}
+
+func optionalETag(needed bool, sum []byte) string {
+	if !needed {
+		return ""
+	}
+	return contentETag(sum)
+}
+
+func contentETag(sum []byte) string {
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:16]))
+}
@@ -0,0 +1,109 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestZipFS(t *testing.T, files map[string]string) fs.FS {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+var fsDownloadTests = []struct {
+	name                string
+	file                string
+	header              http.Header
+	opts                []DownloadOption
+	expectedStatus      int
+	expectedBody        string
+	expectedDisposition string
+}{
+	{name: "whole file", file: "reports/q1.txt", expectedStatus: http.StatusOK, expectedBody: "0123456789", expectedDisposition: `attachment; filename="q1.txt"`},
+	{name: "inline", file: "reports/q1.txt", opts: []DownloadOption{Inline()}, expectedStatus: http.StatusOK, expectedDisposition: `inline; filename="q1.txt"`},
+	{name: "range", file: "reports/q1.txt", header: http.Header{"Range": {"bytes=2-5"}}, expectedStatus: http.StatusPartialContent, expectedBody: "2345"},
+	{name: "missing", file: "reports/q2.txt", expectedStatus: http.StatusNotFound},
+	{name: "directory", file: "reports", expectedStatus: http.StatusNotFound},
+	{name: "traversal", file: "../reports/q1.txt", expectedStatus: http.StatusNotFound},
+}
+
+func TestTools_DownloadFSFile(t *testing.T) {
+	files := map[string]string{"reports/q1.txt": "0123456789"}
+	mapFS := fstest.MapFS{"reports/q1.txt": {Data: []byte(files["reports/q1.txt"]), ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}}
+	filesystems := map[string]fs.FS{"map": mapFS, "zip": newTestZipFS(t, files)}
+
+	var testTools Tools
+	for fsName, fsys := range filesystems {
+		for _, e := range fsDownloadTests {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range e.header {
+				req.Header[k] = v
+			}
+			testTools.DownloadFSFile(rr, req, fsys, e.file, "", e.opts...)
+			if rr.Code != e.expectedStatus {
+				t.Errorf("%s %s: expected status %d but got %d", fsName, e.name, e.expectedStatus, rr.Code)
+				continue
+			}
+			if e.expectedBody != "" && rr.Body.String() != e.expectedBody {
+				t.Errorf("%s %s: expected body %q but got %q", fsName, e.name, e.expectedBody, rr.Body.String())
+			}
+			if e.expectedDisposition != "" && rr.Header().Get("Content-Disposition") != e.expectedDisposition {
+				t.Errorf("%s %s: unexpected disposition %q", fsName, e.name, rr.Header().Get("Content-Disposition"))
+			}
+		}
+	}
+}
+
+func TestTools_DownloadFSFileConditional(t *testing.T) {
+	var testTools Tools
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mapFS := fstest.MapFS{
+		"dated.txt":   {Data: []byte("dated"), ModTime: modTime},
+		"undated.txt": {Data: []byte("undated")},
+	}
+
+	// files with a modification time are validated with If-Modified-Since
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	rr := httptest.NewRecorder()
+	testTools.DownloadFSFile(rr, req, mapFS, "dated.txt", "")
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for If-Modified-Since but got %d", rr.Code)
+	}
+
+	// files without one, like embedded files, get a content ETag
+	rr = httptest.NewRecorder()
+	testTools.DownloadFSFile(rr, httptest.NewRequest("GET", "/", nil), mapFS, "undated.txt", "")
+	etag := rr.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || rr.Body.String() != "undated" {
+		t.Fatalf("expected a content ETag but got %q", etag)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	testTools.DownloadFSFile(rr, req, mapFS, "undated.txt", "")
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for If-None-Match but got %d", rr.Code)
+	}
+}
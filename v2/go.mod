@@ -1,3 +1,5 @@
 module github.com/nrjchnd2/toolkit/v2
 
 go 1.19
+
+require golang.org/x/text v0.14.0
@@ -0,0 +1,353 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// defaultMaxImagePixels caps the size of images when ImagePolicy does not.
+// It fits the largest phone camera photos.
+const defaultMaxImagePixels = 50 * 1000 * 1000
+
+// imageMetadataSlack is room for EXIF, ICC profiles and other metadata when
+// bounding the size of an image file by its pixels.
+const imageMetadataSlack = 4 << 20
+
+// ImagePolicy checks PNG, JPEG and GIF uploads. Dimensions are read from the
+// image header before anything is decoded, so oversized images and
+// decompression bombs are rejected cheaply with ErrImageTooLarge. Other
+// files, including images in other formats, are not affected.
+type ImagePolicy struct {
+	// MaxWidth and MaxHeight limit the size of images as displayed, that
+	// is after any EXIF rotation. Zero means no limit.
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels limits width times height. It defaults to 50 million. The
+	// frames of an animated GIF that is re-encoded must stay under it
+	// together.
+	MaxPixels int64
+	// Reencode decodes and re-encodes images, dropping EXIF, text chunks and
+	// any other metadata or trailing data. JPEGs are rotated as their EXIF
+	// orientation asks first, since that information is lost. Images are
+	// read into memory for this and for Renditions, so files much larger
+	// than their pixels call for are rejected with ErrImageTooLarge.
+	Reencode bool
+	// JPEGQuality is used when re-encoding JPEGs and for JPEG renditions.
+	// It defaults to 90.
+	JPEGQuality int
+	// Renditions are made from every image and stored next to it. They are
+	// not counted against quotas.
+	Renditions []Rendition
+}
+
+func (p *ImagePolicy) enabled() bool {
+	return p.MaxWidth > 0 || p.MaxHeight > 0 || p.MaxPixels > 0 || p.Reencode || len(p.Renditions) > 0
+}
+
+func (p *ImagePolicy) maxPixels() int64 {
+	if p.MaxPixels > 0 {
+		return p.MaxPixels
+	}
+	return defaultMaxImagePixels
+}
+
+// maxImageBytes bounds the size of an image file with the given format and
+// dimensions. Larger files hold more than the pixels they declare, so they
+// are not read into memory.
+func (p *ImagePolicy) maxImageBytes(format string, width, height int) int64 {
+	switch format {
+	case "image/png":
+		// 16-bit RGBA stored uncompressed, with a filter byte per row
+		return (int64(width)*8+1)*int64(height) + imageMetadataSlack
+	case "image/jpeg":
+		return int64(width)*int64(height)*4 + imageMetadataSlack
+	default:
+		// LZW codes take at most 12 bits, and the frames of a GIF may add up
+		// to maxPixels
+		return p.maxPixels()*2 + imageMetadataSlack
+	}
+}
+
+func (p *ImagePolicy) jpegQuality() int {
+	if p.JPEGQuality > 0 {
+		return p.JPEGQuality
+	}
+	return 90
+}
+
+// processImage applies Images to the image in src, recording its size on
+// uploadedFile, and returns the content to store. When the image had to be
+// decoded it is returned too, turned upright. fileType must be a PNG, JPEG or
+// GIF type.
+func (t *Tools) processImage(src io.Reader, fileType string, uploadedFile *UploadedFile) (io.Reader, image.Image, error) {
+	p := &t.Images
+	format := mediaType(fileType)
+
+	// keep what DecodeConfig reads so it can be stored or decoded again
+	head := &bytes.Buffer{}
+	var cfg image.Config
+	var err error
+	switch format {
+	case "image/png":
+		cfg, err = png.DecodeConfig(io.TeeReader(src, head))
+	case "image/jpeg":
+		cfg, err = jpeg.DecodeConfig(io.TeeReader(src, head))
+	case "image/gif":
+		cfg, err = gif.DecodeConfig(io.TeeReader(src, head))
+	default:
+		return nil, nil, fmt.Errorf("unsupported image type %s", fileType)
+	}
+	if err != nil {
+		return nil, nil, &detailedError{ErrInvalidImage, fmt.Sprintf("cannot read image header: %s", err)}
+	}
+
+	orientation := 1
+	if format == "image/jpeg" {
+		orientation = jpegOrientation(head.Bytes())
+	}
+	width, height := cfg.Width, cfg.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	if p.MaxWidth > 0 && width > p.MaxWidth || p.MaxHeight > 0 && height > p.MaxHeight {
+		return nil, nil, &detailedError{ErrImageTooLarge, fmt.Sprintf("image is %dx%d, larger than %dx%d", width, height, p.MaxWidth, p.MaxHeight)}
+	}
+	if int64(width)*int64(height) > p.maxPixels() {
+		return nil, nil, &detailedError{ErrImageTooLarge, fmt.Sprintf("image has %d pixels, more than %d", int64(width)*int64(height), p.maxPixels())}
+	}
+	uploadedFile.Width, uploadedFile.Height = width, height
+
+	rest := io.MultiReader(head, src)
+	if !p.Reencode && len(p.Renditions) == 0 {
+		return rest, nil, nil
+	}
+	// reading everything also runs the checks that wrap src, such as digests
+	limit := p.maxImageBytes(format, cfg.Width, cfg.Height)
+	data, err := io.ReadAll(io.LimitReader(rest, limit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, &detailedError{ErrImageTooLarge, fmt.Sprintf("image file is larger than %d bytes, too large for a %dx%d image", limit, cfg.Width, cfg.Height)}
+	}
+	var img image.Image
+	var anim *gif.GIF
+	switch format {
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/jpeg":
+		if img, err = jpeg.Decode(bytes.NewReader(data)); err == nil {
+			img = orient(img, orientation)
+		}
+	case "image/gif":
+		if !p.Reencode {
+			// renditions only need the first frame
+			var frame image.Image
+			if frame, err = gif.Decode(bytes.NewReader(data)); err == nil {
+				img = gifCanvas(cfg, frame)
+			}
+			break
+		}
+		// every frame is decoded, so check them all before allocating any
+		if pixels := gifPixels(data); pixels > p.maxPixels() {
+			return nil, nil, &detailedError{ErrImageTooLarge, fmt.Sprintf("animation has %d pixels, more than %d", pixels, p.maxPixels())}
+		}
+		if anim, err = gif.DecodeAll(bytes.NewReader(data)); err == nil {
+			img = gifCanvas(cfg, anim.Image[0])
+		}
+	}
+	if err != nil {
+		return nil, nil, &detailedError{ErrInvalidImage, fmt.Sprintf("cannot decode image: %s", err)}
+	}
+	if !p.Reencode {
+		return bytes.NewReader(data), img, nil
+	}
+
+	out := &bytes.Buffer{}
+	switch format {
+	case "image/png":
+		err = png.Encode(out, img)
+	case "image/jpeg":
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: p.jpegQuality()})
+	case "image/gif":
+		err = gif.EncodeAll(out, anim)
+	}
+	if err != nil {
+		return nil, nil, &detailedError{ErrInvalidImage, fmt.Sprintf("cannot re-encode image: %s", err)}
+	}
+	return out, img, nil
+}
+
+// gifCanvas returns frame drawn on a canvas of the size in cfg.
+func gifCanvas(cfg image.Config, frame image.Image) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	return canvas
+}
+
+// gifPixels adds up the sizes of the frames in a GIF by walking its blocks,
+// without decoding any of them. It stops counting where data is malformed,
+// leaving the error to the decoder.
+func gifPixels(data []byte) int64 {
+	const headerLen = 13
+	if len(data) < headerLen {
+		return 0
+	}
+	i := headerLen
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&7 + 1)
+	}
+	// skipSubBlocks moves i past a sequence of data sub-blocks
+	skipSubBlocks := func() bool {
+		for i < len(data) {
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	var pixels int64
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			i += 2
+			if !skipSubBlocks() {
+				return pixels
+			}
+		case 0x2c: // image descriptor
+			if i+10 > len(data) {
+				return pixels
+			}
+			width := int64(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int64(binary.LittleEndian.Uint16(data[i+7:]))
+			pixels += width * height
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&7 + 1)
+			}
+			// the LZW code size comes before the image data
+			i++
+			if !skipSubBlocks() {
+				return pixels
+			}
+		default: // trailer or garbage
+			return pixels
+		}
+	}
+	return pixels
+}
+
+// isProcessableImage reports whether processImage handles fileType.
+func isProcessableImage(fileType string) bool {
+	switch mediaType(fileType) {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// jpegOrientation returns the EXIF orientation, 1 to 8, of the JPEG starting
+// with data, or 1 when it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || size < 2 || i+2+size > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient turns img the way EXIF orientation o describes, so that it displays
+// correctly without the tag.
+func orient(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
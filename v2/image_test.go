@@ -0,0 +1,203 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// pngChunk encodes a PNG chunk with its length and checksum.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// newPNGHeader returns the start of a PNG claiming to be width x height,
+// without any image data behind it.
+func newPNGHeader(width, height int) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(width))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(height))
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	return append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr)...)
+}
+
+// newTestImage returns a width x height image that is red in the top left
+// corner and blue everywhere else.
+func newTestImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{B: 255, A: 255})
+			if x < 8 && y < 8 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+// newTestPNG encodes a test image with a text chunk after its header.
+func newTestPNG(t *testing.T, width, height int, text string) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, newTestImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// the signature and IHDR chunk take 33 bytes
+	withText := append([]byte{}, data[:33]...)
+	withText = append(withText, pngChunk("tEXt", []byte("Comment\x00"+text))...)
+	return append(withText, data[33:]...)
+}
+
+// newTestJPEG encodes a test image with an EXIF segment holding orientation.
+func newTestJPEG(t *testing.T, width, height, orientation int) []byte {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, newTestImage(width, height), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01")
+	tiff = append(tiff, 0, byte(orientation), 0, 0, 0, 0, 0, 0)
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xff, 0xe1}, binary.BigEndian.AppendUint16(nil, uint16(len(exif)+2))...)
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(data, app1...)
+	data = append(data, exif...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+// newTestAnimation encodes a GIF of frames frames, each width x height.
+func newTestAnimation(t *testing.T, frames, width, height int) []byte {
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White})
+		frame.SetColorIndex(i%width, 0, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFPixels(t *testing.T) {
+	if pixels := gifPixels(newTestAnimation(t, 7, 30, 20)); pixels != 7*30*20 {
+		t.Errorf("expected %d pixels but got %d", 7*30*20, pixels)
+	}
+	if pixels := gifPixels(newTestAnimation(t, 7, 30, 20)[:100]); pixels > 7*30*20 {
+		t.Errorf("unexpected %d pixels for a truncated animation", pixels)
+	}
+}
+
+func TestTools_UploadImageLimits(t *testing.T) {
+	var imageTests = []struct {
+		name           string
+		policy         ImagePolicy
+		fileName       string
+		content        []byte
+		expectedWidth  int
+		expectedHeight int
+		expectedErr    error
+	}{
+		{name: "within limits", policy: ImagePolicy{MaxWidth: 64, MaxHeight: 64}, fileName: "a.png", content: newTestPNG(t, 64, 16, "x"), expectedWidth: 64, expectedHeight: 16},
+		{name: "too wide", policy: ImagePolicy{MaxWidth: 32}, fileName: "a.png", content: newTestPNG(t, 64, 16, "x"), expectedErr: ErrImageTooLarge},
+		{name: "too many pixels", policy: ImagePolicy{MaxPixels: 1000}, fileName: "a.png", content: newTestPNG(t, 64, 16, "x"), expectedErr: ErrImageTooLarge},
+		{name: "decompression bomb", policy: ImagePolicy{MaxWidth: 100000}, fileName: "a.png", content: newPNGHeader(50000, 50000), expectedErr: ErrImageTooLarge},
+		{name: "rotated", policy: ImagePolicy{MaxWidth: 20}, fileName: "a.jpg", content: newTestJPEG(t, 32, 16, 6), expectedWidth: 16, expectedHeight: 32},
+		{name: "rotated too tall", policy: ImagePolicy{MaxHeight: 20}, fileName: "a.jpg", content: newTestJPEG(t, 32, 16, 6), expectedErr: ErrImageTooLarge},
+		{name: "invalid", policy: ImagePolicy{MaxWidth: 64}, fileName: "a.png", content: pngContent, expectedErr: ErrInvalidImage},
+		{name: "animation too large", policy: ImagePolicy{MaxPixels: 50000, Reencode: true}, fileName: "a.gif", content: newTestAnimation(t, 10, 100, 100), expectedErr: ErrImageTooLarge},
+		{name: "animation with renditions", policy: ImagePolicy{MaxPixels: 50000, Renditions: []Rendition{{Name: "thumb", Width: 10}}}, fileName: "a.gif", content: newTestAnimation(t, 10, 100, 100), expectedWidth: 100, expectedHeight: 100},
+		{name: "padded", policy: ImagePolicy{Reencode: true}, fileName: "a.png", content: append(newPNGHeader(1, 1), pngChunk("zzZz", make([]byte, 5<<20))...), expectedErr: ErrImageTooLarge},
+		{name: "padded with renditions", policy: ImagePolicy{Renditions: []Rendition{{Name: "thumb", Width: 10}}}, fileName: "a.png", content: append(newPNGHeader(1, 1), pngChunk("zzZz", make([]byte, 5<<20))...), expectedErr: ErrImageTooLarge},
+		{name: "not inspected", fileName: "a.png", content: newPNGHeader(50000, 50000)},
+		{name: "not an image", policy: ImagePolicy{MaxWidth: 64}, fileName: "a.pdf", content: pdfContent},
+	}
+
+	for _, e := range imageTests {
+		testTools := Tools{Storage: NewMemoryStorage(), Images: e.policy}
+		uploadedFile, err := testTools.UploadOneFile(newTypedUploadRequest(t, e.fileName, "", e.content), "uploads")
+		if e.expectedErr != nil {
+			if !errors.Is(err, e.expectedErr) {
+				t.Errorf("%s: expected %v but got %v", e.name, e.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if uploadedFile.Width != e.expectedWidth || uploadedFile.Height != e.expectedHeight {
+			t.Errorf("%s: expected %dx%d but got %dx%d", e.name, e.expectedWidth, e.expectedHeight, uploadedFile.Width, uploadedFile.Height)
+		}
+		if uploadedFile.FileSize != int64(len(e.content)) {
+			t.Errorf("%s: expected the file to be stored unchanged but got %d bytes", e.name, uploadedFile.FileSize)
+		}
+	}
+}
+
+func TestTools_UploadImageReencode(t *testing.T) {
+	storage := NewMemoryStorage()
+	testTools := Tools{Storage: storage, Images: ImagePolicy{Reencode: true}}
+
+	var reencodeTests = []struct {
+		name     string
+		fileName string
+		content  []byte
+		metadata string
+		// redX and redY is where the red corner should end up
+		redX, redY     int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{name: "png", fileName: "a.png", content: newTestPNG(t, 32, 16, "secret"), metadata: "secret", redX: 0, redY: 0, expectedWidth: 32, expectedHeight: 16},
+		{name: "jpeg", fileName: "a.jpg", content: newTestJPEG(t, 32, 16, 1), metadata: "Exif", redX: 0, redY: 0, expectedWidth: 32, expectedHeight: 16},
+		{name: "rotated jpeg", fileName: "a.jpg", content: newTestJPEG(t, 32, 16, 6), metadata: "Exif", redX: 15, redY: 0, expectedWidth: 16, expectedHeight: 32},
+		{name: "mirrored jpeg", fileName: "a.jpg", content: newTestJPEG(t, 32, 16, 4), metadata: "Exif", redX: 0, redY: 15, expectedWidth: 32, expectedHeight: 16},
+	}
+
+	for _, e := range reencodeTests {
+		uploadedFile, err := testTools.UploadOneFile(newTypedUploadRequest(t, e.fileName, "", e.content), "uploads")
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		r, info, err := storage.Get(context.Background(), uploadedFile.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		if bytes.Contains(data, []byte(e.metadata)) {
+			t.Errorf("%s: metadata was not stripped", e.name)
+		}
+		if info.Size != uploadedFile.FileSize {
+			t.Errorf("%s: expected size %d but got %d", e.name, info.Size, uploadedFile.FileSize)
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if img.Bounds().Dx() != e.expectedWidth || img.Bounds().Dy() != e.expectedHeight {
+			t.Errorf("%s: expected %dx%d but got %v", e.name, e.expectedWidth, e.expectedHeight, img.Bounds())
+		}
+		if uploadedFile.Width != e.expectedWidth || uploadedFile.Height != e.expectedHeight {
+			t.Errorf("%s: expected %dx%d but recorded %dx%d", e.name, e.expectedWidth, e.expectedHeight, uploadedFile.Width, uploadedFile.Height)
+		}
+		if red, _, blue, _ := img.At(e.redX, e.redY).RGBA(); red < 0xc000 || blue > 0x4000 {
+			t.Errorf("%s: expected red at %d,%d", e.name, e.redX, e.redY)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Logger receives structured events from Tools. Its methods take a message
+// followed by alternating keys and values, so a *slog.Logger can be used
+// directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+func (t *Tools) logger() Logger {
+	if t.Logger == nil {
+		return nopLogger{}
+	}
+	return t.Logger
+}
+
+// requestAttrs returns the attributes identifying r in log events.
+func requestAttrs(r *http.Request) []interface{} {
+	return []interface{}{"request_id", r.Header.Get("X-Request-ID"), "method", r.Method, "path", r.URL.Path}
+}
+
+// errorKind names the kind of err for log events.
+func errorKind(err error) string {
+	var jsonErr *JSONDecodeError
+	var remoteErr *RemoteError
+	switch {
+	case errors.As(err, &remoteErr):
+		return "remote_status"
+	case errors.Is(err, ErrFileTooLarge):
+		return "file_too_large"
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return "file_type_not_allowed"
+	case errors.Is(err, ErrFileTypeMismatch):
+		return "file_type_mismatch"
+	case errors.Is(err, ErrImageTooLarge):
+		return "image_too_large"
+	case errors.Is(err, ErrInvalidImage):
+		return "invalid_image"
+	case errors.Is(err, ErrDigestMismatch):
+		return "digest_mismatch"
+	case errors.Is(err, ErrInvalidFileName):
+		return "invalid_file_name"
+	case errors.Is(err, ErrFileExists):
+		return "file_exists"
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, ErrUploadRolledBack):
+		return "upload_rolled_back"
+	case errors.Is(err, ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.Is(err, ErrEmptySlug):
+		return "empty_slug"
+	case errors.Is(err, ErrInvalidSignature):
+		return "invalid_signature"
+	case errors.Is(err, ErrSignatureExpired):
+		return "signature_expired"
+	case errors.Is(err, ErrInvalidURLSignature):
+		return "invalid_url_signature"
+	case errors.Is(err, ErrURLExpired):
+		return "url_expired"
+	case errors.Is(err, ErrMissingSecret):
+		return "missing_secret"
+	case errors.As(err, &jsonErr):
+		return "json_" + jsonErr.Kind.String()
+	}
+	return "other"
+}
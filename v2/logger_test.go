@@ -0,0 +1,87 @@
+package toolkit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type logRecord struct {
+	level string
+	msg   string
+	attrs map[string]interface{}
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	attrs := make(map[string]interface{})
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[args[i].(string)] = args[i+1]
+	}
+	l.records = append(l.records, logRecord{level: level, msg: msg, attrs: attrs})
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+func (l *testLogger) find(msg string) []logRecord {
+	var found []logRecord
+	for _, r := range l.records {
+		if r.msg == msg {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func TestTools_Logger(t *testing.T) {
+	logger := &testLogger{}
+	testTools := Tools{
+		Logger:          logger,
+		Storage:         NewMemoryStorage(),
+		StreamUploads:   true,
+		AllowedFileType: []string{"image/png"},
+	}
+
+	req := newUploadRequest(t, "a.png", "b.txt")
+	req.Header.Set("X-Request-ID", "req-1")
+	testTools.UploadFilesWithResults(req, "uploads")
+	saved := logger.find("upload saved")
+	if len(saved) != 1 || saved[0].level != "info" || saved[0].attrs["request_id"] != "req-1" || saved[0].attrs["file_name"] != "a.png" {
+		t.Errorf("unexpected upload saved events %+v", saved)
+	}
+	rejected := logger.find("upload rejected")
+	if len(rejected) != 1 || rejected[0].attrs["error_kind"] != "file_type_not_allowed" {
+		t.Errorf("unexpected upload rejected events %+v", rejected)
+	}
+
+	req = httptest.NewRequest("POST", "/things", bytes.NewReader([]byte(`{"foo":1}`)))
+	var v struct {
+		Foo string `json:"foo"`
+	}
+	testTools.ReadJSON(httptest.NewRecorder(), req, &v)
+	decode := logger.find("json decode failed")
+	if len(decode) != 1 || decode[0].attrs["path"] != "/things" || decode[0].attrs["error_kind"] != "json_type" || decode[0].attrs["status"] != http.StatusUnprocessableEntity {
+		t.Errorf("unexpected json events %+v", decode)
+	}
+
+	client := NewTestClient(func(*http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(bytes.NewBufferString("ok")), Header: make(http.Header)}
+	})
+	testTools.PushJSONTORemote("http://example.com/hook", v, client)
+	remote := logger.find("remote call")
+	if len(remote) != 1 || remote[0].attrs["status"] != http.StatusAccepted || remote[0].attrs["uri"] != "http://example.com/hook" {
+		t.Errorf("unexpected remote call events %+v", remote)
+	}
+}
@@ -0,0 +1,114 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details object. Extensions are written as
+// additional top level members.
+type Problem struct {
+	Type       string                 `json:"type,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Status     int                    `json:"status,omitempty"`
+	Detail     string                 `json:"detail,omitempty"`
+	Instance   string                 `json:"instance,omitempty"`
+	Extensions map[string]interface{} `json:"-"`
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	// the standard members can't be overridden by extensions
+	type problem Problem
+	out, err := json.Marshal(problem(p))
+	if err != nil {
+		return nil, err
+	}
+	var standard map[string]interface{}
+	if err := json.Unmarshal(out, &standard); err != nil {
+		return nil, err
+	}
+	for k, v := range standard {
+		members[k] = v
+	}
+	return json.Marshal(members)
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	type problem Problem
+	if err := json.Unmarshal(data, (*problem)(p)); err != nil {
+		return err
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, k)
+	}
+	p.Extensions = nil
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// NewProblem describes err as a Problem. The status defaults to the one
+// ErrorStatus picks for err, and errors from ReadJSON add the kind, field and
+// offset of the failure as extensions.
+func NewProblem(err error, status ...int) *Problem {
+	statusCode := ErrorStatus(err)
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: err.Error(),
+	}
+	var jsonErr *JSONDecodeError
+	if errors.As(err, &jsonErr) {
+		p.Extensions = map[string]interface{}{"kind": jsonErr.Kind.String()}
+		if jsonErr.Field != "" {
+			p.Extensions["field"] = jsonErr.Field
+		}
+		if jsonErr.Offset > 0 {
+			p.Extensions["offset"] = jsonErr.Offset
+		}
+	}
+	return p
+}
+
+// WriteProblem writes p as application/problem+json.
+func (t *Tools) WriteProblem(w http.ResponseWriter, p *Problem, headers ...http.Header) error {
+	out, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if len(headers) > 0 {
+		for k, v := range headers[0] {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(out)
+	return err
+}
+
+// ErrorProblem writes err as application/problem+json, whatever ProblemJSON
+// is set to.
+func (t *Tools) ErrorProblem(w http.ResponseWriter, err error, status ...int) error {
+	p := NewProblem(err, status...)
+	t.logger().Debug("error response", "status", p.Status, "error", err, "error_kind", errorKind(err))
+	return t.WriteProblem(w, p)
+}
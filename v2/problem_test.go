@@ -0,0 +1,101 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProblem_MarshalJSON(t *testing.T) {
+	p := Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "You do not have enough credit.",
+		Status:     http.StatusForbidden,
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]interface{}{"balance": 30, "status": "ignored"},
+	}
+	out, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members map[string]interface{}
+	json.Unmarshal(out, &members)
+	if members["balance"] != float64(30) || members["status"] != float64(http.StatusForbidden) {
+		t.Errorf("unexpected members %s", out)
+	}
+	if _, ok := members["detail"]; ok {
+		t.Errorf("empty members should be omitted: %s", out)
+	}
+
+	var decoded Problem
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != p.Title || decoded.Extensions["balance"] != float64(30) || len(decoded.Extensions) != 1 {
+		t.Errorf("unexpected problem %+v", decoded)
+	}
+}
+
+var problemTests = []struct {
+	name        string
+	problemJSON bool
+	err         error
+	status      int
+	contentType string
+}{
+	{name: "json response", problemJSON: false, err: ErrFileTypeNotAllowed, status: http.StatusUnsupportedMediaType, contentType: "application/json"},
+	{name: "problem", problemJSON: true, err: ErrFileTypeNotAllowed, status: http.StatusUnsupportedMediaType, contentType: "application/problem+json"},
+	{name: "json error", problemJSON: true, err: &JSONDecodeError{Kind: JSONUnknownField, Field: "fooo"}, status: http.StatusUnprocessableEntity, contentType: "application/problem+json"},
+}
+
+func TestTools_ErrorJsonProblem(t *testing.T) {
+	for _, e := range problemTests {
+		testTools := Tools{ProblemJSON: e.problemJSON}
+		rr := httptest.NewRecorder()
+		if err := testTools.ErrorJson(rr, e.err); err != nil {
+			t.Error(err)
+		}
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != e.contentType {
+			t.Errorf("%s: wrong content type %s", e.name, ct)
+		}
+		if !e.problemJSON {
+			continue
+		}
+		var p Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		if p.Status != e.status || p.Detail != e.err.Error() || p.Title != http.StatusText(e.status) {
+			t.Errorf("%s: unexpected problem %+v", e.name, p)
+		}
+		var jsonErr *JSONDecodeError
+		if errors.As(e.err, &jsonErr) && (p.Extensions["kind"] != "unknown_field" || p.Extensions["field"] != "fooo") {
+			t.Errorf("%s: missing extensions %+v", e.name, p.Extensions)
+		}
+	}
+
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	testTools.ErrorProblem(rr, errors.New("conflict"), http.StatusConflict)
+	if rr.Code != http.StatusConflict || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestTools_WriteProblem(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	headers := http.Header{"Content-Type": {"text/plain"}, "Retry-After": {"5"}}
+	testTools.WriteProblem(rr, &Problem{Title: "busy", Status: http.StatusServiceUnavailable}, headers)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != "application/problem+json" || rr.Header().Get("Retry-After") != "5" {
+		t.Errorf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+	if headers.Get("Content-Type") != "text/plain" {
+		t.Error("caller headers must not be changed")
+	}
+}
@@ -0,0 +1,312 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// quotaChunk is how many bytes are reserved at a time while a file is read,
+// so the QuotaStore is not called for every read.
+const quotaChunk = 1024 * 1024
+
+// Quota limits what a single identity may store. Zero fields are unlimited.
+type Quota struct {
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// Usage is what an identity has stored, or wants to store.
+type Usage struct {
+	Bytes int64
+	Files int64
+}
+
+// QuotaStore keeps track of the usage of every identity. Reserve must check
+// and add in one step, so that concurrent uploads cannot overshoot a quota
+// together.
+type QuotaStore interface {
+	// Reserve adds delta to the usage of identity, or fails with
+	// ErrQuotaExceeded and changes nothing if that would exceed limit.
+	Reserve(ctx context.Context, identity string, delta Usage, limit Quota) error
+	// Release subtracts delta from the usage of identity.
+	Release(ctx context.Context, identity string, delta Usage) error
+	Usage(ctx context.Context, identity string) (Usage, error)
+}
+
+// addUsage applies delta to u if the result stays within limit.
+func addUsage(u, delta Usage, limit Quota) (Usage, error) {
+	next := Usage{Bytes: u.Bytes + delta.Bytes, Files: u.Files + delta.Files}
+	if limit.MaxBytes > 0 && delta.Bytes > 0 && next.Bytes > limit.MaxBytes {
+		return u, &detailedError{ErrQuotaExceeded, fmt.Sprintf("storage quota of %d bytes exceeded", limit.MaxBytes)}
+	}
+	if limit.MaxFiles > 0 && delta.Files > 0 && next.Files > limit.MaxFiles {
+		return u, &detailedError{ErrQuotaExceeded, fmt.Sprintf("quota of %d files exceeded", limit.MaxFiles)}
+	}
+	return next, nil
+}
+
+// subUsage subtracts delta from u, never going below zero.
+func subUsage(u, delta Usage) Usage {
+	u.Bytes -= delta.Bytes
+	u.Files -= delta.Files
+	if u.Bytes < 0 {
+		u.Bytes = 0
+	}
+	if u.Files < 0 {
+		u.Files = 0
+	}
+	return u
+}
+
+// MemoryQuotaStore keeps usage in memory, so it starts from zero whenever the
+// process does.
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]Usage
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: make(map[string]Usage)}
+}
+
+func (s *MemoryQuotaStore) Reserve(ctx context.Context, identity string, delta Usage, limit Quota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage == nil {
+		s.usage = make(map[string]Usage)
+	}
+	next, err := addUsage(s.usage[identity], delta, limit)
+	if err != nil {
+		return err
+	}
+	s.usage[identity] = next
+	return nil
+}
+
+func (s *MemoryQuotaStore) Release(ctx context.Context, identity string, delta Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage == nil {
+		return nil
+	}
+	s.usage[identity] = subUsage(s.usage[identity], delta)
+	return nil
+}
+
+func (s *MemoryQuotaStore) Usage(ctx context.Context, identity string) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[identity], nil
+}
+
+// FileQuotaStore keeps usage in a JSON file at Path, which is replaced
+// atomically on every change. Changes are serialised within the process, so
+// a file must not be shared by several processes.
+type FileQuotaStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileQuotaStore) Reserve(ctx context.Context, identity string, delta Usage, limit Quota) error {
+	return s.update(func(usage map[string]Usage) error {
+		next, err := addUsage(usage[identity], delta, limit)
+		if err != nil {
+			return err
+		}
+		usage[identity] = next
+		return nil
+	})
+}
+
+func (s *FileQuotaStore) Release(ctx context.Context, identity string, delta Usage) error {
+	return s.update(func(usage map[string]Usage) error {
+		usage[identity] = subUsage(usage[identity], delta)
+		return nil
+	})
+}
+
+func (s *FileQuotaStore) Usage(ctx context.Context, identity string) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage, err := s.load()
+	if err != nil {
+		return Usage{}, err
+	}
+	return usage[identity], nil
+}
+
+func (s *FileQuotaStore) update(change func(map[string]Usage) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := change(usage); err != nil {
+		return err
+	}
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	const mode = 0755
+	if err := os.MkdirAll(filepath.Dir(s.Path), mode); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *FileQuotaStore) load() (map[string]Usage, error) {
+	usage := make(map[string]Usage)
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// identity returns who an upload in r is counted against.
+func (t *Tools) identity(r *http.Request) string {
+	if t.Quotas == nil || t.Identity == nil {
+		return ""
+	}
+	return t.Identity(r)
+}
+
+func (t *Tools) quotaLimit(identity string) Quota {
+	if t.QuotaLimit == nil {
+		return Quota{}
+	}
+	return t.QuotaLimit(identity)
+}
+
+// reserveUpfront reserves delta for identity before an upload of known size
+// arrives, so that concurrent uploads cannot promise more than the quota
+// holds. It does nothing without Quotas.
+func (t *Tools) reserveUpfront(ctx context.Context, identity string, delta Usage) error {
+	if t.Quotas == nil {
+		return nil
+	}
+	return t.Quotas.Reserve(ctx, identity, delta, t.quotaLimit(identity))
+}
+
+// releaseUpfront gives back what reserveUpfront reserved.
+func (t *Tools) releaseUpfront(identity string, delta Usage) {
+	if t.Quotas == nil || delta == (Usage{}) {
+		return
+	}
+	// the request may already be canceled, but the usage must still be
+	// given back
+	t.Quotas.Release(context.Background(), identity, delta)
+}
+
+// ReleaseQuota gives back the usage of a file saved for identity, for
+// callers that delete uploaded files. It does nothing without Quotas.
+func (t *Tools) ReleaseQuota(ctx context.Context, identity string, f *UploadedFile) error {
+	if t.Quotas == nil {
+		return nil
+	}
+	return t.Quotas.Release(ctx, identity, fileUsage(f))
+}
+
+// fileUsage is what f counts against a quota. Duplicates take no space of
+// their own.
+func fileUsage(f *UploadedFile) Usage {
+	if f.Duplicate {
+		return Usage{Files: 1}
+	}
+	return Usage{Bytes: f.FileSize, Files: 1}
+}
+
+// quotaReader reserves the bytes read through it against the quota of an
+// identity, failing the read once the quota is used up.
+type quotaReader struct {
+	ctx      context.Context
+	store    QuotaStore
+	identity string
+	limit    Quota
+	r        io.Reader
+	read     int64
+	reserved Usage
+}
+
+// reserveQuota returns a reader that reserves the bytes of r for identity as
+// they are read, beyond reserved, which was set aside before the file
+// arrived. One file is reserved first unless reserved already holds it. It
+// returns nil, nil without Quotas.
+func (t *Tools) reserveQuota(ctx context.Context, identity string, r io.Reader, reserved Usage) (*quotaReader, error) {
+	if t.Quotas == nil {
+		return nil, nil
+	}
+	q := &quotaReader{ctx: ctx, store: t.Quotas, identity: identity, limit: t.quotaLimit(identity), r: r, reserved: reserved}
+	if reserved.Files == 0 {
+		if err := q.store.Reserve(ctx, identity, Usage{Files: 1}, q.limit); err != nil {
+			return nil, err
+		}
+		q.reserved.Files = 1
+	}
+	return q, nil
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.read += int64(n)
+	if need := q.read - q.reserved.Bytes; need > 0 {
+		chunk := need
+		if chunk < quotaChunk {
+			chunk = quotaChunk
+		}
+		rerr := q.store.Reserve(q.ctx, q.identity, Usage{Bytes: chunk}, q.limit)
+		// near the limit a whole chunk may not fit while the file does
+		if errors.Is(rerr, ErrQuotaExceeded) && chunk > need {
+			chunk = need
+			rerr = q.store.Reserve(q.ctx, q.identity, Usage{Bytes: chunk}, q.limit)
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+		q.reserved.Bytes += chunk
+	}
+	return n, err
+}
+
+// settle keeps keep of the reservation and releases the rest.
+func (q *quotaReader) settle(keep Usage) error {
+	surplus := Usage{Bytes: q.reserved.Bytes - keep.Bytes, Files: q.reserved.Files - keep.Files}
+	q.reserved = keep
+	if surplus.Bytes == 0 && surplus.Files == 0 {
+		return nil
+	}
+	// the upload context may already be canceled, but the usage must still
+	// be given back
+	return q.store.Release(context.Background(), q.identity, surplus)
+}
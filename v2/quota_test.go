@@ -0,0 +1,222 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var quotaStoreTests = []struct {
+	name  string
+	store func() QuotaStore
+}{
+	{name: "memory", store: func() QuotaStore { return NewMemoryQuotaStore() }},
+	{name: "file", store: func() QuotaStore { return &FileQuotaStore{Path: "./testdata/quota/usage.json"} }},
+}
+
+func TestQuotaStore(t *testing.T) {
+	defer os.RemoveAll("./testdata/quota")
+	ctx := context.Background()
+	limit := Quota{MaxBytes: 100, MaxFiles: 2}
+	for _, e := range quotaStoreTests {
+		os.RemoveAll("./testdata/quota")
+		store := e.store()
+		if err := store.Reserve(ctx, "alice", Usage{Bytes: 60, Files: 1}, limit); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if err := store.Reserve(ctx, "alice", Usage{Bytes: 41}, limit); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("%s: expected the byte quota to be exceeded but got %v", e.name, err)
+		}
+		if err := store.Reserve(ctx, "alice", Usage{Files: 2}, limit); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("%s: expected the file quota to be exceeded but got %v", e.name, err)
+		}
+		if err := store.Reserve(ctx, "bob", Usage{Bytes: 100, Files: 2}, limit); err != nil {
+			t.Errorf("%s: identities must not share a quota: %s", e.name, err)
+		}
+		store.Release(ctx, "alice", Usage{Bytes: 100, Files: 0})
+		if usage, _ := e.store().Usage(ctx, "alice"); e.name == "file" && usage != (Usage{Files: 1}) {
+			t.Errorf("%s: expected usage to persist but got %+v", e.name, usage)
+		}
+		if usage, _ := store.Usage(ctx, "alice"); usage != (Usage{Files: 1}) {
+			t.Errorf("%s: unexpected usage %+v", e.name, usage)
+		}
+	}
+}
+
+func TestQuotaStore_Concurrent(t *testing.T) {
+	defer os.RemoveAll("./testdata/quota")
+	ctx := context.Background()
+	for _, e := range quotaStoreTests {
+		os.RemoveAll("./testdata/quota")
+		store := e.store()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		granted := 0
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if store.Reserve(ctx, "alice", Usage{Bytes: 10}, Quota{MaxBytes: 100}) == nil {
+					mu.Lock()
+					granted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if usage, _ := store.Usage(ctx, "alice"); granted != 10 || usage.Bytes != 100 {
+			t.Errorf("%s: expected 10 reservations and 100 bytes but got %d and %+v", e.name, granted, usage)
+		}
+	}
+}
+
+func newQuotaTools(limit Quota) (*Tools, *MemoryQuotaStore, *MemoryStorage) {
+	quotas := NewMemoryQuotaStore()
+	storage := NewMemoryStorage()
+	return &Tools{
+		Storage:       storage,
+		StreamUploads: true,
+		Quotas:        quotas,
+		Identity:      func(r *http.Request) string { return r.Header.Get("X-User") },
+		QuotaLimit:    func(string) Quota { return limit },
+	}, quotas, storage
+}
+
+func TestTools_UploadQuota(t *testing.T) {
+	ctx := context.Background()
+	testTools, quotas, storage := newQuotaTools(Quota{MaxFiles: 2, MaxBytes: 30})
+
+	upload := func(user string, names ...string) ([]*UploadResult, error) {
+		req := newUploadRequest(t, names...)
+		req.Header.Set("X-User", user)
+		return testTools.UploadFilesWithResults(req, "uploads/"+user)
+	}
+
+	// "hello from a.txt" is 16 bytes, so only one such file fits in 30
+	results, _ := upload("alice", "a.txt", "b.txt")
+	if results[0].Status != UploadSaved || !errors.Is(results[1].Err, ErrQuotaExceeded) || results[1].Status != UploadRejected {
+		t.Fatalf("expected the second file to exceed the byte quota but got %v / %v", results[0].Err, results[1].Err)
+	}
+	if usage, _ := quotas.Usage(ctx, "alice"); usage != (Usage{Bytes: 16, Files: 1}) {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if objects, _ := storage.List(ctx, "uploads/alice/"); len(objects) != 1 {
+		t.Errorf("expected the rejected file to be removed but found %d objects", len(objects))
+	}
+
+	// other identities have their own quota
+	if results, _ := upload("bob", "c.txt"); results[0].Err != nil {
+		t.Errorf("unexpected error for another user: %s", results[0].Err)
+	}
+
+	// rolled back files give their usage back
+	testTools.AllOrNothing = true
+	testTools.AllowedFileType = []string{"text/plain; charset=utf-8"}
+	upload("bob", "d.txt", "e.png")
+	if usage, _ := quotas.Usage(ctx, "bob"); usage != (Usage{Bytes: 16, Files: 1}) {
+		t.Errorf("expected the rollback to release its usage but got %+v", usage)
+	}
+}
+
+func TestTools_UploadQuotaDuplicate(t *testing.T) {
+	ctx := context.Background()
+	testTools, quotas, _ := newQuotaTools(Quota{})
+	testTools.Deduplicate = true
+	for i := 0; i < 2; i++ {
+		req := newUploadRequest(t, "a.txt")
+		req.Header.Set("X-User", "alice")
+		if _, err := testTools.UploadOneFile(req, "uploads"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if usage, _ := quotas.Usage(ctx, "alice"); usage != (Usage{Bytes: 16, Files: 2}) {
+		t.Errorf("expected a duplicate to count as a file without bytes but got %+v", usage)
+	}
+}
+
+func TestResumableUploads_Quota(t *testing.T) {
+	ctx := context.Background()
+	testTools, quotas, _ := newQuotaTools(Quota{MaxBytes: 1024})
+	handler := &ResumableUploads{Tools: testTools, UploadDir: "uploads", PartialDir: "./testdata/tus-quota", BasePath: "/files/"}
+	defer os.RemoveAll("./testdata/tus-quota")
+
+	var locations []string
+	for _, e := range []struct {
+		length         string
+		expectedStatus int
+	}{
+		{length: "2048", expectedStatus: http.StatusRequestEntityTooLarge},
+		{length: "512", expectedStatus: http.StatusCreated},
+		{length: "256", expectedStatus: http.StatusCreated},
+		{length: "256", expectedStatus: http.StatusCreated},
+		// unfinished uploads hold their whole length
+		{length: "256", expectedStatus: http.StatusRequestEntityTooLarge},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": e.length, "X-User": "alice"}))
+		if rr.Code != e.expectedStatus {
+			t.Errorf("length %s: expected status %d but got %d", e.length, e.expectedStatus, rr.Code)
+		}
+		if rr.Code == http.StatusCreated {
+			locations = append(locations, rr.Header().Get("Location"))
+		}
+	}
+	if usage, _ := quotas.Usage(ctx, "alice"); usage != (Usage{Bytes: 1024, Files: 3}) {
+		t.Errorf("expected created uploads to be reserved but got %+v", usage)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, locations[0], bytes.NewReader(bytes.Repeat([]byte("a"), 512)), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("unexpected PATCH response %d %s", rr.Code, rr.Body)
+	}
+	if usage, _ := quotas.Usage(ctx, "alice"); usage != (Usage{Bytes: 1024, Files: 3}) {
+		t.Errorf("expected the finished upload to keep its reservation but got %+v", usage)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodDelete, locations[1], nil, nil))
+	if usage, _ := quotas.Usage(ctx, "alice"); rr.Code != http.StatusNoContent || usage != (Usage{Bytes: 768, Files: 2}) {
+		t.Errorf("expected a terminated upload to release its reservation but got %d %+v", rr.Code, usage)
+	}
+
+	handler.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+	if err := handler.RemoveExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := quotas.Usage(ctx, "alice"); usage != (Usage{Bytes: 512, Files: 1}) {
+		t.Errorf("expected an expired upload to release its reservation but got %+v", usage)
+	}
+}
+
+func TestResumableUploads_QuotaRejected(t *testing.T) {
+	ctx := context.Background()
+	testTools, quotas, _ := newQuotaTools(Quota{MaxBytes: 1024})
+	testTools.AllowedFileType = []string{"image/png"}
+	handler := &ResumableUploads{Tools: testTools, UploadDir: "uploads", PartialDir: "./testdata/tus-quota", BasePath: "/files/"}
+	defer os.RemoveAll("./testdata/tus-quota")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "5", "X-User": "alice"}))
+	location := rr.Header().Get("Location")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, location, bytes.NewReader([]byte("hello")), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a text upload but got %d", rr.Code)
+	}
+	if usage, _ := quotas.Usage(ctx, "alice"); usage != (Usage{}) {
+		t.Errorf("expected a rejected upload to release its reservation but got %+v", usage)
+	}
+}
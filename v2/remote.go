@@ -0,0 +1,242 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how PushJSON retries requests that failed with a
+// network error, a 5xx status or 429 Too Many Requests. Delays grow
+// exponentially from BaseDelay up to MaxDelay with random jitter, unless
+// the response carries a Retry-After header. Retry-After is honoured up to
+// MaxDelay too, so a receiver cannot stall the caller for longer.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+const (
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+)
+
+// PushOption configures a single PushJSON call.
+type PushOption func(*pushOptions)
+
+type pushOptions struct {
+	client *http.Client
+	header http.Header
+	retry  RetryPolicy
+	auth   AuthProvider
+	signer *WebhookSigner
+}
+
+// WithClient sends the request with c instead of http.DefaultClient.
+func WithClient(c *http.Client) PushOption {
+	return func(o *pushOptions) {
+		o.client = c
+	}
+}
+
+// WithHeader adds a header to the request.
+func WithHeader(key, value string) PushOption {
+	return func(o *pushOptions) {
+		o.header.Add(key, value)
+	}
+}
+
+// WithAuth adds credentials from a to every attempt.
+func WithAuth(a AuthProvider) PushOption {
+	return func(o *pushOptions) {
+		o.auth = a
+	}
+}
+
+// WithRetry retries failed requests according to p.
+func WithRetry(p RetryPolicy) PushOption {
+	return func(o *pushOptions) {
+		o.retry = p
+	}
+}
+
+// PushJSON posts data encoded as JSON to uri. Non-2xx responses are not
+// errors; the response is returned with its body unread and the caller must
+// close it.
+func (t *Tools) PushJSON(ctx context.Context, uri string, data interface{}, opts ...PushOption) (*http.Response, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return t.send(ctx, "POST", uri, jsonData, opts...)
+}
+
+// PushJSONDecode posts data as JSON to uri and decodes the JSON reply into
+// target with the same rules ReadJSON applies to request bodies. A non-2xx
+// reply is returned as a *RemoteError. The status code is returned whenever
+// a response was received.
+func (t *Tools) PushJSONDecode(ctx context.Context, uri string, data, target interface{}, opts ...PushOption) (int, error) {
+	res, err := t.PushJSON(ctx, uri, data, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return res.StatusCode, t.decodeResponse(res, target)
+}
+
+// maxRemoteErrorBody is how much of a non-2xx reply RemoteError keeps.
+const maxRemoteErrorBody = 1024
+
+// RemoteError is returned when a remote service replies with a non-2xx
+// status. Body holds the start of the reply.
+type RemoteError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *RemoteError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("remote service returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("remote service returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// decodeResponse decodes the JSON reply in res into target and closes the
+// body. A nil target or a reply without a body skips decoding.
+func (t *Tools) decodeResponse(res *http.Response, target interface{}) error {
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxRemoteErrorBody+1))
+		remoteErr := &RemoteError{StatusCode: res.StatusCode, Body: string(body)}
+		if len(body) > maxRemoteErrorBody {
+			remoteErr.Body = string(body[:maxRemoteErrorBody]) + "..."
+		}
+		return remoteErr
+	}
+	if target == nil || res.StatusCode == http.StatusNoContent || res.ContentLength == 0 {
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+		return nil
+	}
+	maxBytes := defaultMaxJSONSize
+	if t.MaxJSONSize > 0 {
+		maxBytes = t.MaxJSONSize
+	}
+	return t.decodeJSON(http.MaxBytesReader(nil, res.Body, int64(maxBytes)), target, maxBytes)
+}
+
+// send makes the request, retrying as the options allow. body is sent as
+// JSON unless it is nil.
+func (t *Tools) send(ctx context.Context, method, uri string, body []byte, opts ...PushOption) (*http.Response, error) {
+	o := pushOptions{client: http.DefaultClient, header: make(http.Header)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.signer != nil && len(o.signer.Secret) == 0 {
+		return nil, ErrMissingSecret
+	}
+	for attempt := 1; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range o.header {
+			request.Header[k] = v
+		}
+		if o.auth != nil {
+			if err := o.auth.Authorize(request); err != nil {
+				return nil, err
+			}
+		}
+		if o.signer != nil {
+			o.signer.Sign(request, body)
+		}
+
+		start := time.Now()
+		res, err := o.client.Do(request)
+		if err != nil {
+			t.logger().Error("remote call failed", "method", method, "uri", uri, "attempt", attempt, "error", err)
+		} else {
+			t.logger().Info("remote call", "method", method, "uri", uri, "status", res.StatusCode, "attempt", attempt, "duration", time.Since(start))
+		}
+		if attempt >= o.retry.MaxAttempts || !retryable(res, err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		delay := o.retry.delay(attempt, res)
+		if res != nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+			res.Body.Close()
+		}
+		t.logger().Warn("remote call retry", "method", method, "uri", uri, "attempt", attempt, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 && res.StatusCode != http.StatusNotImplemented
+}
+
+// delay returns how long to wait before the attempt after attempt.
+func (p RetryPolicy) delay(attempt int, res *http.Response) time.Duration {
+	base, limit := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if limit <= 0 {
+		limit = defaultRetryMaxDelay
+	}
+	if res != nil {
+		if d, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+			if d > limit {
+				d = limit
+			}
+			return d
+		}
+	}
+	d := base
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	// keep at least half the delay so retries still back off
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter parses a Retry-After header holding either seconds or a date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		d := time.Until(at)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
@@ -0,0 +1,178 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var pushJSONTests = []struct {
+	name             string
+	statuses         []int
+	retryAfter       string
+	maxAttempts      int
+	expectedStatus   int
+	expectedAttempts int32
+}{
+	{name: "no retry needed", statuses: []int{200}, maxAttempts: 3, expectedStatus: 200, expectedAttempts: 1},
+	{name: "retry on 5xx", statuses: []int{503, 502, 200}, maxAttempts: 3, expectedStatus: 200, expectedAttempts: 3},
+	{name: "retry on 429", statuses: []int{429, 200}, retryAfter: "0", maxAttempts: 3, expectedStatus: 200, expectedAttempts: 2},
+	{name: "gives up", statuses: []int{500, 500, 500}, maxAttempts: 2, expectedStatus: 500, expectedAttempts: 2},
+	{name: "no retry on 4xx", statuses: []int{400, 200}, maxAttempts: 3, expectedStatus: 400, expectedAttempts: 1},
+	{name: "retries disabled", statuses: []int{503, 200}, maxAttempts: 0, expectedStatus: 503, expectedAttempts: 1},
+}
+
+func TestTools_PushJSON(t *testing.T) {
+	var testTools Tools
+	for _, e := range pushJSONTests {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&attempts, 1)
+			var payload map[string]string
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload["foo"] != "bar" || r.Header.Get("X-Event") != "created" || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s: unexpected request %v %v", e.name, payload, r.Header)
+			}
+			if e.retryAfter != "" {
+				w.Header().Set("Retry-After", e.retryAfter)
+			}
+			w.WriteHeader(e.statuses[n-1])
+			io.WriteString(w, "reply")
+		}))
+		res, err := testTools.PushJSON(context.Background(), srv.URL, map[string]string{"foo": "bar"},
+			WithHeader("X-Event", "created"),
+			WithRetry(RetryPolicy{MaxAttempts: e.maxAttempts, BaseDelay: time.Millisecond}))
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			srv.Close()
+			continue
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != e.expectedStatus || string(body) != "reply" {
+			t.Errorf("%s: unexpected response %d %q", e.name, res.StatusCode, body)
+		}
+		if attempts != e.expectedAttempts {
+			t.Errorf("%s: expected %d attempts but got %d", e.name, e.expectedAttempts, attempts)
+		}
+		srv.Close()
+	}
+}
+
+func TestTools_PushJSONContext(t *testing.T) {
+	var testTools Tools
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := testTools.PushJSON(ctx, srv.URL, "x", WithRetry(RetryPolicy{MaxAttempts: 5}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded but got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("context did not stop the retry wait")
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, limit := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		d := p.delay(attempt, nil)
+		if d < limit/2 || d > limit {
+			t.Errorf("attempt %d: delay %s outside [%s, %s]", attempt, d, limit/2, limit)
+		}
+	}
+	res := &http.Response{Header: http.Header{"Retry-After": {"7"}}}
+	if d := (RetryPolicy{MaxDelay: 10 * time.Second}).delay(1, res); d != 7*time.Second {
+		t.Errorf("expected Retry-After to be honoured but got %s", d)
+	}
+	if d := p.delay(1, res); d != time.Second {
+		t.Errorf("expected Retry-After to be capped at MaxDelay but got %s", d)
+	}
+	res = &http.Response{Header: http.Header{"Retry-After": {"86400"}}}
+	if d := (RetryPolicy{}).delay(1, res); d != defaultRetryMaxDelay {
+		t.Errorf("expected Retry-After to be capped at the default MaxDelay but got %s", d)
+	}
+}
+
+var pushJSONDecodeTests = []struct {
+	name        string
+	status      int
+	reply       string
+	maxJSONSize int
+	checkErr    func(error) bool
+}{
+	{name: "decoded", status: 200, reply: `{"id":7}`, checkErr: func(err error) bool { return err == nil }},
+	{name: "no content", status: 204, reply: ``, checkErr: func(err error) bool { return err == nil }},
+	{name: "unknown field", status: 200, reply: `{"id":7,"extra":1}`, checkErr: func(err error) bool {
+		var jsonErr *JSONDecodeError
+		return errors.As(err, &jsonErr) && jsonErr.Kind == JSONUnknownField
+	}},
+	{name: "two values", status: 200, reply: `{"id":7}{"id":8}`, checkErr: func(err error) bool {
+		var jsonErr *JSONDecodeError
+		return errors.As(err, &jsonErr) && jsonErr.Kind == JSONMultipleValues
+	}},
+	{name: "too large", status: 200, reply: `{"id":7}`, maxJSONSize: 4, checkErr: func(err error) bool { return errors.Is(err, ErrBodyTooLarge) }},
+	{name: "remote error", status: 500, reply: `{"error":"boom"}`, checkErr: func(err error) bool {
+		var remoteErr *RemoteError
+		return errors.As(err, &remoteErr) && remoteErr.StatusCode == 500 && remoteErr.Body == `{"error":"boom"}`
+	}},
+}
+
+func TestTools_PushJSONDecode(t *testing.T) {
+	for _, e := range pushJSONDecodeTests {
+		testTools := Tools{MaxJSONSize: e.maxJSONSize}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(e.status)
+			io.WriteString(w, e.reply)
+		}))
+		var reply struct {
+			ID int `json:"id"`
+		}
+		status, err := testTools.PushJSONDecode(context.Background(), srv.URL, map[string]string{"foo": "bar"}, &reply)
+		if status != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, status)
+		}
+		if !e.checkErr(err) {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if e.name == "decoded" && reply.ID != 7 {
+			t.Errorf("%s: reply not decoded", e.name)
+		}
+		srv.Close()
+	}
+}
+
+func TestRemoteError_Truncated(t *testing.T) {
+	var testTools Tools
+	long := make([]byte, 5000)
+	for i := range long {
+		long[i] = 'x'
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(long)
+	}))
+	defer srv.Close()
+	_, err := testTools.PushJSONDecode(context.Background(), srv.URL, nil, nil)
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || len(remoteErr.Body) != maxRemoteErrorBody+3 {
+		t.Errorf("expected truncated remote error but got %v", err)
+	}
+	if ErrorStatus(err) != http.StatusBadGateway {
+		t.Errorf("expected remote errors to map to 502")
+	}
+}
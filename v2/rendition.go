@@ -0,0 +1,223 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"path"
+	"strings"
+)
+
+// RenditionMode decides how an image is fitted into the size of a Rendition.
+type RenditionMode int
+
+const (
+	// RenditionFit scales the image to fit within the size, keeping its
+	// aspect ratio.
+	RenditionFit RenditionMode = iota
+	// RenditionCrop scales the image to cover the size and crops what is
+	// left over, keeping the center.
+	RenditionCrop
+)
+
+// Rendition is a smaller copy of uploaded images, such as a thumbnail, that
+// is stored next to the original. Images are never scaled up; with
+// RenditionCrop small images are only cropped to the aspect ratio of the size.
+type Rendition struct {
+	// Name is added to the key of the original to make the key of the
+	// rendition, so "uploads/photo.jpg" gets "uploads/photo_thumb.jpg".
+	// FileNameCollision applies when that key is taken.
+	Name string
+	// Width and Height are the size to scale to. With RenditionFit either
+	// may be zero to only limit the other.
+	Width  int
+	Height int
+	Mode   RenditionMode
+}
+
+// RenditionFile describes a rendition stored for an uploaded image.
+type RenditionFile struct {
+	Name        string
+	NewFileName string
+	Key         string
+	FileSize    int64
+	Width       int
+	Height      int
+}
+
+func (r *Rendition) validate() error {
+	if r.Name == "" || strings.ContainsAny(r.Name, `/\`) {
+		return fmt.Errorf("invalid rendition name %q", r.Name)
+	}
+	if r.Width < 0 || r.Height < 0 || r.Width == 0 && r.Height == 0 ||
+		r.Mode == RenditionCrop && (r.Width == 0 || r.Height == 0) {
+		return fmt.Errorf("invalid size %dx%d for rendition %s", r.Width, r.Height, r.Name)
+	}
+	return nil
+}
+
+// render returns img scaled and cropped as r describes.
+func (r *Rendition) render(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	var scale float64
+	crop := b
+	switch r.Mode {
+	case RenditionCrop:
+		// the largest part of img with the aspect ratio of r
+		s := math.Min(w/float64(r.Width), h/float64(r.Height))
+		cw := int(math.Min(w, math.Round(float64(r.Width)*s)))
+		ch := int(math.Min(h, math.Round(float64(r.Height)*s)))
+		scale = math.Min(1, 1/s)
+		crop = image.Rect(0, 0, cw, ch).Add(b.Min).Add(image.Pt((b.Dx()-cw)/2, (b.Dy()-ch)/2))
+	default:
+		scale = 1
+		if r.Width > 0 {
+			scale = math.Min(scale, float64(r.Width)/w)
+		}
+		if r.Height > 0 {
+			scale = math.Min(scale, float64(r.Height)/h)
+		}
+	}
+	dw := int(math.Max(1, math.Round(float64(crop.Dx())*scale)))
+	dh := int(math.Max(1, math.Round(float64(crop.Dy())*scale)))
+	return resize(img, crop, dw, dh)
+}
+
+// storeRenditions renders the renditions in Images from img, the decoded
+// upload, and stores them next to uploadedFile. PNG and JPEG renditions keep
+// the format of the original, GIFs become PNGs.
+func (t *Tools) storeRenditions(ctx context.Context, uploadedFile *UploadedFile, img image.Image, fileType string) error {
+	ext, contentType := ".png", "image/png"
+	if mediaType(fileType) == "image/jpeg" {
+		ext, contentType = ".jpg", "image/jpeg"
+	}
+	dir := path.Dir(uploadedFile.Key)
+	stem := strings.TrimSuffix(path.Base(uploadedFile.Key), path.Ext(uploadedFile.Key))
+	for _, rendition := range t.Images.Renditions {
+		if err := rendition.validate(); err != nil {
+			return err
+		}
+		scaled := rendition.render(img)
+		buf := &bytes.Buffer{}
+		var err error
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(buf, scaled, &jpeg.Options{Quality: t.Images.jpegQuality()})
+		} else {
+			err = png.Encode(buf, scaled)
+		}
+		if err != nil {
+			return err
+		}
+		suffix := "_" + rendition.Name + ext
+		key := path.Join(dir, stem+suffix)
+		// deduplicated renditions are named after their content like the
+		// original, so rewriting them is harmless; other names may belong to
+		// files uploaded earlier
+		if !t.Deduplicate {
+			name := truncateUTF8(stem, maxFileNameLength-len(suffix)) + suffix
+			if key, _, err = t.unusedFileKey(ctx, dir, name); err != nil {
+				return err
+			}
+		}
+		info, err := t.storage().Put(ctx, key, buf, contentType)
+		if err != nil {
+			return err
+		}
+		uploadedFile.Renditions = append(uploadedFile.Renditions, RenditionFile{
+			Name:        rendition.Name,
+			NewFileName: path.Base(info.Key),
+			Key:         info.Key,
+			FileSize:    info.Size,
+			Width:       scaled.Bounds().Dx(),
+			Height:      scaled.Bounds().Dy(),
+		})
+	}
+	return nil
+}
+
+// resize scales the part r of img to width x height, averaging the pixels
+// each output pixel covers with a triangle filter, one axis at a time.
+func resize(img image.Image, r image.Rectangle, width, height int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(src, src.Bounds(), img, r.Min, draw.Src)
+	if width == r.Dx() && height == r.Dy() {
+		return src
+	}
+	tmp := image.NewRGBA(image.Rect(0, 0, width, r.Dy()))
+	for x, c := range filterWeights(r.Dx(), width) {
+		for y := 0; y < r.Dy(); y++ {
+			var sum [4]float64
+			for i, weight := range c.weights {
+				offset := src.PixOffset(c.start+i, y)
+				for k := range sum {
+					sum[k] += weight * float64(src.Pix[offset+k])
+				}
+			}
+			setPixel(tmp, x, y, sum)
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range filterWeights(r.Dy(), height) {
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for i, weight := range c.weights {
+				offset := tmp.PixOffset(x, c.start+i)
+				for k := range sum {
+					sum[k] += weight * float64(tmp.Pix[offset+k])
+				}
+			}
+			setPixel(dst, x, y, sum)
+		}
+	}
+	return dst
+}
+
+func setPixel(img *image.RGBA, x, y int, rgba [4]float64) {
+	offset := img.PixOffset(x, y)
+	for k, v := range rgba {
+		img.Pix[offset+k] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+	}
+}
+
+// filterWeight lists the source pixels, from start on, that make up one
+// output pixel and how much each one counts.
+type filterWeight struct {
+	start   int
+	weights []float64
+}
+
+// filterWeights computes the weights for scaling srcLen pixels to dstLen.
+// The triangle filter is widened when scaling down, so every source pixel
+// contributes. The source pixel nearest to the center always has a weight,
+// so the total is never zero.
+func filterWeights(srcLen, dstLen int) []filterWeight {
+	scale := float64(srcLen) / float64(dstLen)
+	support := math.Max(scale, 1)
+	result := make([]filterWeight, dstLen)
+	for i := range result {
+		center := (float64(i) + 0.5) * scale
+		start := int(math.Max(0, math.Floor(center-support)))
+		end := int(math.Min(float64(srcLen), math.Ceil(center+support)))
+		var weights []float64
+		total := 0.0
+		for j := start; j < end; j++ {
+			weight := 1 - math.Abs(float64(j)+0.5-center)/support
+			if weight < 0 {
+				weight = 0
+			}
+			weights = append(weights, weight)
+			total += weight
+		}
+		for j := range weights {
+			weights[j] /= total
+		}
+		result[i] = filterWeight{start: start, weights: weights}
+	}
+	return result
+}
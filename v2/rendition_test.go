@@ -0,0 +1,177 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// storedImage decodes the image stored under key.
+func storedImage(t *testing.T, storage Storage, key string) (image.Image, string) {
+	r, _, err := storage.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, format
+}
+
+func newTestGIF(t *testing.T, width, height int) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.RGBA{B: 255, A: 255}, color.RGBA{R: 255, A: 255}})
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetColorIndex(x, y, 1)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTools_UploadRenditions(t *testing.T) {
+	var renditionTests = []struct {
+		name      string
+		fileName  string
+		content   []byte
+		reencode  bool
+		rendition Rendition
+		key       string
+		format    string
+		width     int
+		height    int
+		// the red corner should be at redX, redY unless it was cropped away
+		red        bool
+		redX, redY int
+	}{
+		{name: "fit", fileName: "a.png", content: newTestPNG(t, 64, 32, "x"), rendition: Rendition{Name: "small", Width: 16, Height: 16}, key: "uploads/a_small.png", format: "png", width: 16, height: 8, red: true},
+		{name: "fit width", fileName: "a.png", content: newTestPNG(t, 64, 32, "x"), rendition: Rendition{Name: "w32", Width: 32}, key: "uploads/a_w32.png", format: "png", width: 32, height: 16, red: true},
+		{name: "not scaled up", fileName: "a.png", content: newTestPNG(t, 64, 32, "x"), rendition: Rendition{Name: "large", Width: 512, Height: 512}, key: "uploads/a_large.png", format: "png", width: 64, height: 32, red: true},
+		{name: "crop", fileName: "a.png", content: newTestPNG(t, 64, 32, "x"), rendition: Rendition{Name: "thumb", Width: 16, Height: 16, Mode: RenditionCrop}, key: "uploads/a_thumb.png", format: "png", width: 16, height: 16},
+		{name: "crop small image", fileName: "a.png", content: newTestPNG(t, 64, 32, "x"), rendition: Rendition{Name: "thumb", Width: 48, Height: 48, Mode: RenditionCrop}, key: "uploads/a_thumb.png", format: "png", width: 32, height: 32},
+		{name: "rotated jpeg", fileName: "a.jpeg", content: newTestJPEG(t, 32, 16, 6), rendition: Rendition{Name: "thumb", Width: 16, Height: 16}, key: "uploads/a_thumb.jpg", format: "jpeg", width: 8, height: 16, red: true, redX: 7},
+		{name: "reencoded jpeg", fileName: "a.jpg", content: newTestJPEG(t, 32, 16, 6), reencode: true, rendition: Rendition{Name: "thumb", Width: 16, Height: 16}, key: "uploads/a_thumb.jpg", format: "jpeg", width: 8, height: 16, red: true, redX: 7},
+		{name: "gif", fileName: "a.gif", content: newTestGIF(t, 64, 32), rendition: Rendition{Name: "small", Width: 32, Height: 32}, key: "uploads/a_small.png", format: "png", width: 32, height: 16, red: true},
+	}
+
+	for _, e := range renditionTests {
+		storage := NewMemoryStorage()
+		testTools := Tools{Storage: storage, Images: ImagePolicy{Reencode: e.reencode, Renditions: []Rendition{e.rendition}}}
+		uploadedFile, err := testTools.UploadOneFile(newTypedUploadRequest(t, e.fileName, "", e.content), "uploads", false)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if !e.reencode && uploadedFile.FileSize != int64(len(e.content)) {
+			t.Errorf("%s: expected the original to be stored unchanged but got %d bytes", e.name, uploadedFile.FileSize)
+		}
+		if len(uploadedFile.Renditions) != 1 {
+			t.Errorf("%s: expected one rendition but got %d", e.name, len(uploadedFile.Renditions))
+			continue
+		}
+		rendition := uploadedFile.Renditions[0]
+		if rendition.Name != e.rendition.Name || rendition.Key != e.key || rendition.Width != e.width || rendition.Height != e.height {
+			t.Errorf("%s: unexpected rendition %+v", e.name, rendition)
+		}
+		img, format := storedImage(t, storage, e.key)
+		if format != e.format || img.Bounds().Dx() != e.width || img.Bounds().Dy() != e.height {
+			t.Errorf("%s: expected a %dx%d %s but got a %v %s", e.name, e.width, e.height, e.format, img.Bounds(), format)
+		}
+		red, _, blue, _ := img.At(e.redX, e.redY).RGBA()
+		if e.red && (red < 0xc000 || blue > 0x4000) {
+			t.Errorf("%s: expected red at %d,%d", e.name, e.redX, e.redY)
+		}
+	}
+}
+
+func TestTools_UploadRenditionsRemoved(t *testing.T) {
+	storage := NewMemoryStorage()
+	testTools := Tools{
+		Storage:         storage,
+		AllowedFileType: []string{"image/png"},
+		AllOrNothing:    true,
+		Images:          ImagePolicy{Renditions: []Rendition{{Name: "thumb", Width: 16, Height: 16}}},
+	}
+	if _, err := testTools.UploadFiles(newUploadRequest(t, "a.png", "b.txt"), "uploads"); err == nil {
+		t.Error("expected the upload to be rolled back")
+	}
+
+	testTools = Tools{Storage: storage, Images: ImagePolicy{Renditions: []Rendition{{Name: "thumb", Width: 16, Height: 16}, {Name: "bad/name", Width: 16}}}}
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, "a.png"), "uploads"); err == nil {
+		t.Error("expected an error for an invalid rendition")
+	}
+
+	objects, _ := storage.List(context.Background(), "")
+	if len(objects) != 0 {
+		t.Errorf("expected no stored objects but got %d", len(objects))
+	}
+}
+
+func TestTools_UploadRenditionsCollision(t *testing.T) {
+	ctx := context.Background()
+	for _, e := range []struct {
+		name      string
+		collision CollisionPolicy
+		key       string
+		err       error
+	}{
+		{name: "overwrite", collision: CollisionOverwrite, key: "uploads/a_thumb.png"},
+		{name: "error", collision: CollisionError, err: ErrFileExists},
+		{name: "suffix", collision: CollisionSuffix, key: "uploads/a_thumb-1.png"},
+	} {
+		storage := NewMemoryStorage()
+		storage.Put(ctx, "uploads/a_thumb.png", strings.NewReader("mine"), "text/plain")
+		testTools := Tools{
+			Storage:           storage,
+			FileNameCollision: e.collision,
+			Images:            ImagePolicy{Renditions: []Rendition{{Name: "thumb", Width: 16, Height: 16}}},
+		}
+		uploadedFile, err := testTools.UploadOneFile(newUploadRequest(t, "a.png"), "uploads", false)
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.err, err)
+			continue
+		}
+		if err != nil {
+			if _, err := storage.Stat(ctx, "uploads/a.png"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: expected the original to be removed", e.name)
+			}
+		} else if len(uploadedFile.Renditions) != 1 || uploadedFile.Renditions[0].Key != e.key {
+			t.Errorf("%s: unexpected renditions %+v", e.name, uploadedFile.Renditions)
+		}
+		if info, _ := storage.Stat(ctx, "uploads/a_thumb.png"); e.collision != CollisionOverwrite && info.Size != 4 {
+			t.Errorf("%s: expected the existing file to be kept", e.name)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 10, 110, 47))
+	for i := range src.Pix {
+		src.Pix[i] = []byte{200, 100, 50, 255}[i%4]
+	}
+	for _, size := range []image.Point{{13, 5}, {100, 1}, {1, 37}, {99, 36}} {
+		dst := resize(src, src.Bounds(), size.X, size.Y)
+		if dst.Bounds().Size() != size {
+			t.Errorf("expected %v but got %v", size, dst.Bounds().Size())
+		}
+		for i, v := range dst.Pix {
+			if v != src.Pix[i%4] {
+				t.Errorf("%v: solid color changed to %v", size, dst.Pix[i-i%4:i-i%4+4])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultS3PartSize = 8 * 1024 * 1024
+
+// s3MaxCopySize is the largest object a single CopyObject request can copy,
+// and the part size used to copy larger ones.
+var s3MaxCopySize int64 = 5 * 1024 * 1024 * 1024
+
+// S3Storage stores objects in a bucket of an S3 compatible object store.
+// Objects larger than PartSize are sent using a multipart upload. Delete
+// succeeds for missing keys, as S3 itself does. Rename copies objects within
+// the service, so their data never passes through this process.
+type S3Storage struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PathStyle puts the bucket in the path instead of the host name, as
+	// most self hosted S3 implementations expect.
+	PathStyle bool
+	// PartSize is the size of each part of a multipart upload. S3 requires
+	// at least 5 MiB; it defaults to 8 MiB.
+	PartSize int64
+	Client   *http.Client
+}
+
+// S3Error is an error response returned by an S3 compatible service.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s (status %d)", e.Code, e.Message, e.StatusCode)
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) (*ObjectInfo, error) {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = defaultS3PartSize
+	}
+	buff := make([]byte, partSize)
+	n, err := io.ReadFull(r, buff)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putObject(ctx, key, buff[:n], contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.putMultipart(ctx, key, buff, r, contentType)
+}
+
+func (s *S3Storage) putObject(ctx context.Context, key string, data []byte, contentType string) (*ObjectInfo, error) {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	res, err := s.do(ctx, "PUT", key, nil, header, data)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return &ObjectInfo{
+		Key:         key,
+		Bucket:      s.Bucket,
+		Size:        int64(len(data)),
+		ModTime:     time.Now(),
+		ContentType: contentType,
+		ETag:        strings.Trim(res.Header.Get("ETag"), `"`),
+	}, nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads first followed by the rest of r in parts of
+// len(first) bytes. The upload is aborted if anything fails.
+func (s *S3Storage) putMultipart(ctx context.Context, key string, first []byte, r io.Reader, contentType string) (*ObjectInfo, error) {
+	uploadID, err := s.initiateMultipart(ctx, key, contentType)
+	if err != nil {
+		return nil, err
+	}
+	complete, size, err := s.uploadParts(ctx, key, uploadID, first, r)
+	var etag string
+	if err == nil {
+		etag, err = s.completeMultipart(ctx, key, uploadID, complete)
+	}
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:         key,
+		Bucket:      s.Bucket,
+		Size:        size,
+		ModTime:     time.Now(),
+		ContentType: contentType,
+		ETag:        etag,
+	}, nil
+}
+
+func (s *S3Storage) initiateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(ctx, "POST", key, url.Values{"uploads": {""}}, header, nil, &initiated); err != nil {
+		return "", err
+	}
+	return initiated.UploadID, nil
+}
+
+// completeMultipart assembles the parts of an upload and returns the ETag of
+// the new object.
+func (s *S3Storage) completeMultipart(ctx context.Context, key, uploadID string, parts []s3CompletedPart) (string, error) {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+	if err := s.doXML(ctx, "POST", key, url.Values{"uploadId": {uploadID}}, nil, body, &result); err != nil {
+		return "", err
+	}
+	return strings.Trim(result.ETag, `"`), nil
+}
+
+func (s *S3Storage) abortMultipart(key, uploadID string) {
+	// abort with a fresh context, the request's one may be what failed
+	if res, err := s.do(context.Background(), "DELETE", key, url.Values{"uploadId": {uploadID}}, nil, nil); err == nil {
+		res.Body.Close()
+	}
+}
+
+func (s *S3Storage) uploadParts(ctx context.Context, key, uploadID string, buff []byte, r io.Reader) ([]s3CompletedPart, int64, error) {
+	var parts []s3CompletedPart
+	var size int64
+	n := len(buff)
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+		res, err := s.do(ctx, "PUT", key, query, nil, buff[:n])
+		if err != nil {
+			return nil, 0, err
+		}
+		res.Body.Close()
+		parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: res.Header.Get("ETag")})
+		size += int64(n)
+
+		n, err = io.ReadFull(r, buff)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, 0, err
+		}
+	}
+	return parts, size, nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	res, err := s.do(ctx, "GET", key, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body, s.objectInfo(key, res), nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	res, err := s.do(ctx, "HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return s.objectInfo(key, res), nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	res, err := s.do(ctx, "DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Rename(ctx context.Context, from, to string) error {
+	info, err := s.Stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	if info.Size <= s3MaxCopySize {
+		err = s.copyObject(ctx, from, to)
+	} else {
+		err = s.copyMultipart(ctx, from, to, info)
+	}
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, from)
+}
+
+// copySource is the X-Amz-Copy-Source header naming key.
+func (s *S3Storage) copySource(key string) http.Header {
+	return http.Header{"X-Amz-Copy-Source": {s3Escape("/"+s.Bucket+"/"+key, false)}}
+}
+
+func (s *S3Storage) copyObject(ctx context.Context, from, to string) error {
+	// CopyObject also reports some failures in a 200 response, which doXML
+	// catches
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+	return s.doXML(ctx, "PUT", to, nil, s.copySource(from), nil, &result)
+}
+
+// copyMultipart copies objects too large for CopyObject part by part.
+func (s *S3Storage) copyMultipart(ctx context.Context, from, to string, info *ObjectInfo) error {
+	uploadID, err := s.initiateMultipart(ctx, to, info.ContentType)
+	if err != nil {
+		return err
+	}
+	var parts []s3CompletedPart
+	for start, partNumber := int64(0), 1; start < info.Size && err == nil; start, partNumber = start+s3MaxCopySize, partNumber+1 {
+		end := start + s3MaxCopySize
+		if end > info.Size {
+			end = info.Size
+		}
+		header := s.copySource(from)
+		header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		if err = s.doXML(ctx, "PUT", to, query, header, nil, &result); err == nil {
+			parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: result.ETag})
+		}
+	}
+	if err == nil {
+		_, err = s.completeMultipart(ctx, to, uploadID, parts)
+	}
+	if err != nil {
+		s.abortMultipart(to, uploadID)
+	}
+	return err
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	var objects []*ObjectInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+				ETag         string    `xml:"ETag"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := s.doXML(ctx, "GET", "", query, nil, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, &ObjectInfo{
+				Key:     c.Key,
+				Bucket:  s.Bucket,
+				Size:    c.Size,
+				ModTime: c.LastModified,
+				ETag:    strings.Trim(c.ETag, `"`),
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) objectInfo(key string, res *http.Response) *ObjectInfo {
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return &ObjectInfo{
+		Key:         key,
+		Bucket:      s.Bucket,
+		Size:        res.ContentLength,
+		ModTime:     modTime,
+		ContentType: res.Header.Get("Content-Type"),
+		ETag:        strings.Trim(res.Header.Get("ETag"), `"`),
+	}
+}
+
+func (s *S3Storage) doXML(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, v interface{}) error {
+	res, err := s.do(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	// CompleteMultipartUpload reports some failures in a 200 response
+	if bytes.Contains(data, []byte("<Error>")) {
+		return s3ResponseError(res.StatusCode, data)
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// do sends a signed request for key and returns the response if it has a
+// 2xx status.
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := "/" + key
+	if s.PathStyle {
+		p = "/" + s.Bucket + p
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+
+	request, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	signer := SigV4Signer{
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.SecretAccessKey,
+		SessionToken:    s.SessionToken,
+		Region:          s.Region,
+		Service:         "s3",
+	}
+	sum := sha256.Sum256(body)
+	signer.Sign(request, hex.EncodeToString(sum[:]), time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound && key != "" && method != "POST" {
+		return nil, &fs.PathError{Op: strings.ToLower(method), Path: key, Err: fs.ErrNotExist}
+	}
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	return nil, s3ResponseError(res.StatusCode, data)
+}
+
+func s3ResponseError(status int, data []byte) error {
+	s3Err := &S3Error{StatusCode: status}
+	xml.Unmarshal(data, s3Err)
+	return s3Err
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4.
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+}
+
+// Sign adds the X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers
+// to r. Every header already set on r is included in the signature.
+// payloadHash is the hex encoded SHA-256 of the body, or UNSIGNED-PAYLOAD.
+func (s *SigV4Signer) Sign(r *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" {
+			continue
+		}
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = strings.Join(strings.Fields(v[i]), " ")
+		}
+		headers[k] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3Escape(r.URL.Path, false),
+		s3Query(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query encodes query sorted by key, the form SigV4 signs.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything except the RFC 3986 unreserved
+// characters, and '/' unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,187 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidURLSignature = errors.New("download link is invalid")
+	ErrURLExpired          = errors.New("download link has expired")
+)
+
+// URLSigner creates download links that stay valid until they expire, and
+// checks them when they come back. The file key, expiry, download name and
+// any bound client IP or user are covered by an HMAC-SHA256 signature, so a
+// link can be handed out without a session.
+type URLSigner struct {
+	Secret []byte
+	// BaseURL is where ServeSignedDownloads is mounted; signed parameters
+	// are added to its query.
+	BaseURL string
+	// ClientIP returns the address links bound with BindIP are checked
+	// against. It defaults to the host of r.RemoteAddr; set it when running
+	// behind a proxy.
+	ClientIP func(r *http.Request) string
+	// User returns the user links bound with BindUser are checked against.
+	User func(r *http.Request) string
+
+	now func() time.Time
+}
+
+// SignedURLOption adds restrictions to a single signed link.
+type SignedURLOption func(*signedURL)
+
+type signedURL struct {
+	key         string
+	expires     int64
+	displayName string
+	ip          string
+	user        string
+	bind        []string
+}
+
+// BindIP makes a link valid only for requests from ip.
+func BindIP(ip string) SignedURLOption {
+	return func(u *signedURL) {
+		u.ip = ip
+		u.bind = append(u.bind, "ip")
+	}
+}
+
+// BindUser makes a link valid only for requests URLSigner.User maps to user.
+func BindUser(user string) SignedURLOption {
+	return func(u *signedURL) {
+		u.user = user
+		u.bind = append(u.bind, "user")
+	}
+}
+
+// WithDownloadName sets the file name the browser saves the download as.
+func WithDownloadName(name string) SignedURLOption {
+	return func(u *signedURL) {
+		u.displayName = name
+	}
+}
+
+// SignURL returns a link to the stored file key that expires after ttl.
+func (s *URLSigner) SignURL(key string, ttl time.Duration, opts ...SignedURLOption) (string, error) {
+	if len(s.Secret) == 0 {
+		return "", ErrMissingSecret
+	}
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	su := signedURL{key: key, expires: s.clock().Add(ttl).Unix()}
+	for _, opt := range opts {
+		opt(&su)
+	}
+	q := u.Query()
+	q.Set("file", su.key)
+	q.Set("expires", strconv.FormatInt(su.expires, 10))
+	if su.displayName != "" {
+		q.Set("name", su.displayName)
+	}
+	if len(su.bind) > 0 {
+		q.Set("bind", strings.Join(su.bind, ","))
+	}
+	q.Set("sig", s.signature(&su))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Verify checks the link r was made for and returns the file key and
+// download name it carries. It fails with ErrMissingSecret when Secret is
+// empty.
+func (s *URLSigner) Verify(r *http.Request) (key, displayName string, err error) {
+	if len(s.Secret) == 0 {
+		return "", "", ErrMissingSecret
+	}
+	q := r.URL.Query()
+	su := signedURL{key: q.Get("file"), displayName: q.Get("name")}
+	su.expires, err = strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || su.key == "" {
+		return "", "", ErrInvalidURLSignature
+	}
+	if bind := q.Get("bind"); bind != "" {
+		for _, b := range strings.Split(bind, ",") {
+			switch {
+			case b == "ip":
+				su.ip = s.clientIP(r)
+			case b == "user" && s.User != nil:
+				su.user = s.User(r)
+			default:
+				return "", "", ErrInvalidURLSignature
+			}
+			su.bind = append(su.bind, b)
+		}
+	}
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(s.signature(&su))) {
+		return "", "", ErrInvalidURLSignature
+	}
+	// the expiry is checked after the signature so it cannot be forged
+	if s.clock().Unix() > su.expires {
+		return "", "", ErrURLExpired
+	}
+	return su.key, su.displayName, nil
+}
+
+func (s *URLSigner) signature(su *signedURL) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	for _, field := range []string{su.key, strconv.FormatInt(su.expires, 10), su.displayName, strings.Join(su.bind, ","), su.ip, su.user} {
+		// the length prefix keeps fields from running into each other
+		mac.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *URLSigner) clientIP(r *http.Request) string {
+	if s.ClientIP != nil {
+		return s.ClientIP(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *URLSigner) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// ServeSignedDownloads returns a handler that serves stored files through
+// links made by s.SignURL, answering 403 for links that are invalid, expired
+// or used from the wrong client.
+func (t *Tools) ServeSignedDownloads(s *URLSigner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		key, displayName, err := s.Verify(r)
+		if err != nil {
+			t.logger().Warn("signed download rejected", append(requestAttrs(r), "error", err, "error_kind", errorKind(err))...)
+			t.ErrorJson(w, err)
+			return
+		}
+		if displayName == "" {
+			displayName = path.Base(key)
+		}
+		t.DownloadStoredFile(w, r, key, displayName)
+	})
+}
@@ -0,0 +1,110 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var signedURLTests = []struct {
+	name           string
+	opts           []SignedURLOption
+	ttl            time.Duration
+	tamper         func(q url.Values)
+	remoteAddr     string
+	user           string
+	expectedStatus int
+}{
+	{name: "valid", ttl: time.Minute, expectedStatus: http.StatusOK},
+	{name: "expired", ttl: -time.Second, expectedStatus: http.StatusForbidden},
+	{name: "other file", ttl: time.Minute, tamper: func(q url.Values) { q.Set("file", "private/other.txt") }, expectedStatus: http.StatusForbidden},
+	{name: "extended expiry", ttl: -time.Second, tamper: func(q url.Values) { q.Set("expires", "99999999999") }, expectedStatus: http.StatusForbidden},
+	{name: "binding removed", ttl: time.Minute, opts: []SignedURLOption{BindIP("10.0.0.1")}, tamper: func(q url.Values) { q.Del("bind") }, remoteAddr: "10.0.0.2:1234", expectedStatus: http.StatusForbidden},
+	{name: "bound ip", ttl: time.Minute, opts: []SignedURLOption{BindIP("10.0.0.1")}, remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK},
+	{name: "wrong ip", ttl: time.Minute, opts: []SignedURLOption{BindIP("10.0.0.1")}, remoteAddr: "10.0.0.2:1234", expectedStatus: http.StatusForbidden},
+	{name: "bound user", ttl: time.Minute, opts: []SignedURLOption{BindUser("alice")}, user: "alice", expectedStatus: http.StatusOK},
+	{name: "wrong user", ttl: time.Minute, opts: []SignedURLOption{BindUser("alice")}, user: "bob", expectedStatus: http.StatusForbidden},
+	{name: "missing signature", ttl: time.Minute, tamper: func(q url.Values) { q.Del("sig") }, expectedStatus: http.StatusForbidden},
+}
+
+func TestTools_ServeSignedDownloads(t *testing.T) {
+	storage := NewMemoryStorage()
+	storage.Put(context.Background(), "private/report.txt", strings.NewReader("report"), "text/plain")
+	testTools := Tools{Storage: storage}
+	signer := &URLSigner{
+		Secret:  []byte("shh"),
+		BaseURL: "https://example.com/downloads?v=1",
+		User:    func(r *http.Request) string { return r.Header.Get("X-User") },
+	}
+	handler := testTools.ServeSignedDownloads(signer)
+
+	for _, e := range signedURLTests {
+		link, err := signer.SignURL("private/report.txt", e.ttl, e.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, _ := url.Parse(link)
+		if u.Query().Get("v") != "1" {
+			t.Errorf("%s: base URL query was lost in %s", e.name, link)
+		}
+		if e.tamper != nil {
+			q := u.Query()
+			e.tamper(q)
+			u.RawQuery = q.Encode()
+		}
+		req := httptest.NewRequest("GET", u.String(), nil)
+		if e.remoteAddr != "" {
+			req.RemoteAddr = e.remoteAddr
+		}
+		req.Header.Set("X-User", e.user)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+			continue
+		}
+		if e.expectedStatus == http.StatusOK && rr.Body.String() != "report" {
+			t.Errorf("%s: unexpected body %q", e.name, rr.Body.String())
+		}
+	}
+}
+
+func TestURLSigner_DownloadName(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	signer := &URLSigner{Secret: []byte("shh"), BaseURL: "/dl", now: func() time.Time { return now }}
+	link, _ := signer.SignURL("private/a1b2.pdf", time.Hour, WithDownloadName("Quarterly report.pdf"))
+	key, name, err := signer.Verify(httptest.NewRequest("GET", link, nil))
+	if err != nil || key != "private/a1b2.pdf" || name != "Quarterly report.pdf" {
+		t.Errorf("unexpected verification result %q %q %v", key, name, err)
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if _, _, err := signer.Verify(httptest.NewRequest("GET", link, nil)); err != ErrURLExpired {
+		t.Errorf("expected ErrURLExpired but got %v", err)
+	}
+}
+
+func TestURLSigner_MissingSecret(t *testing.T) {
+	storage := NewMemoryStorage()
+	storage.Put(context.Background(), "private/report.txt", strings.NewReader("report"), "text/plain")
+	testTools := Tools{Storage: storage}
+	signer := &URLSigner{BaseURL: "/dl"}
+	if _, err := signer.SignURL("private/report.txt", time.Minute); !errors.Is(err, ErrMissingSecret) {
+		t.Errorf("expected ErrMissingSecret but got %v", err)
+	}
+
+	// a link forged with the empty key
+	forged := (&URLSigner{Secret: []byte{}, BaseURL: "/dl"}).signature(&signedURL{key: "private/report.txt", expires: time.Now().Add(time.Hour).Unix()})
+	link := fmt.Sprintf("/dl?file=private/report.txt&expires=%d&sig=%s", time.Now().Add(time.Hour).Unix(), forged)
+	rr := httptest.NewRecorder()
+	testTools.ServeSignedDownloads(signer).ServeHTTP(rr, httptest.NewRequest("GET", link, nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 without a secret but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,477 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// defaultSniffLen is how much of a file SignatureSniffer looks at. Office
+// documents need more than the 512 bytes http.DetectContentType reads to get
+// past the first few entries of their zip container.
+const defaultSniffLen = 8 * 1024
+
+// ContentSniffer detects the type of uploaded files from their content.
+type ContentSniffer interface {
+	// SniffLen is how many leading bytes of a file Sniff wants to see.
+	SniffLen() int
+	// Sniff returns the MIME type of a file starting with head. head is
+	// shorter than SniffLen for short files.
+	Sniff(head []byte) string
+	// Extensions returns the file extensions, with their leading dot, used
+	// for files of mimeType. The most common one comes first.
+	Extensions(mimeType string) []string
+}
+
+// Signature identifies a file type by the bytes found at Offset.
+type Signature struct {
+	MIME       string
+	Extensions []string
+	Offset     int
+	Magic      []byte
+}
+
+func (s *Signature) match(head []byte) bool {
+	return len(head) >= s.Offset+len(s.Magic) && bytes.Equal(head[s.Offset:s.Offset+len(s.Magic)], s.Magic)
+}
+
+// SignatureSniffer is the default ContentSniffer. It knows the formats
+// http.DetectContentType does, telling apart the formats that share a
+// container: Office, OpenDocument and EPUB files inside zip, HEIC, AVIF and
+// the audio and video brands of ISO media files, and the codecs in Ogg and
+// Matroska files. It also recognises TIFF, JPEG XL, FLAC, AAC, MP3 without
+// tags, common archives and executables.
+type SignatureSniffer struct {
+	// Signatures are tried before the built-in ones.
+	Signatures []Signature
+}
+
+func (s *SignatureSniffer) SniffLen() int {
+	return defaultSniffLen
+}
+
+func (s *SignatureSniffer) Sniff(head []byte) string {
+	for i := range s.Signatures {
+		if s.Signatures[i].match(head) {
+			return s.Signatures[i].MIME
+		}
+	}
+	for _, detect := range containerDetectors {
+		if mimeType := detect(head); mimeType != "" {
+			return mimeType
+		}
+	}
+	for i := range signatures {
+		if signatures[i].match(head) {
+			return signatures[i].MIME
+		}
+	}
+	mimeType := http.DetectContentType(head)
+	// MPEG frame headers are too short to rule out text with a byte order
+	// mark, so they are only looked for in what is not recognised otherwise
+	if mimeType == "application/octet-stream" {
+		if audioType := sniffMPEGAudio(head); audioType != "" {
+			return audioType
+		}
+	}
+	if strings.HasPrefix(mimeType, "text/xml") || strings.HasPrefix(mimeType, "text/plain") {
+		if isSVG(head) {
+			return "image/svg+xml"
+		}
+	}
+	return mimeType
+}
+
+func (s *SignatureSniffer) Extensions(mimeType string) []string {
+	mediaType := mimeType
+	if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mediaType = parsed
+	}
+	for i := range s.Signatures {
+		if strings.EqualFold(s.Signatures[i].MIME, mediaType) {
+			return s.Signatures[i].Extensions
+		}
+	}
+	return typeExtensions[strings.ToLower(mediaType)]
+}
+
+func (t *Tools) sniffer() ContentSniffer {
+	if t.Sniffer == nil {
+		return &SignatureSniffer{}
+	}
+	return t.Sniffer
+}
+
+// fileTypeAllowed reports whether AllowedFileType lets files of fileType in.
+// Entries can be a MIME type, with or without parameters, a family such as
+// "image/*", or an extension such as ".pdf", which allows every type the
+// sniffer uses that extension for.
+func (t *Tools) fileTypeAllowed(fileType string) bool {
+	if len(t.AllowedFileType) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(fileType)
+	if err != nil {
+		mediaType = fileType
+	}
+	for _, allowed := range t.AllowedFileType {
+		switch {
+		case strings.EqualFold(allowed, fileType), strings.EqualFold(allowed, mediaType):
+			return true
+		case strings.HasSuffix(allowed, "/*"):
+			if strings.HasPrefix(strings.ToLower(mediaType), strings.ToLower(strings.TrimSuffix(allowed, "*"))) {
+				return true
+			}
+		case strings.HasPrefix(allowed, "."):
+			for _, ext := range t.sniffer().Extensions(fileType) {
+				if strings.EqualFold(ext, allowed) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// containerDetectors look inside formats that hold several kinds of files,
+// or whose magic is too short to be trusted alone.
+var containerDetectors = []func(head []byte) string{
+	sniffZip,
+	sniffISOMedia,
+	sniffOgg,
+	sniffMatroska,
+	sniffPE,
+	sniffBzip2,
+}
+
+// signatures are the fixed magic numbers http.DetectContentType does not know.
+var signatures = []Signature{
+	{MIME: "image/tiff", Magic: []byte("II*\x00")},
+	{MIME: "image/tiff", Magic: []byte("MM\x00*")},
+	{MIME: "image/jxl", Magic: []byte("\xff\x0a")},
+	{MIME: "image/jxl", Magic: []byte("\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a")},
+	{MIME: "image/vnd.adobe.photoshop", Magic: []byte("8BPS")},
+	{MIME: "audio/flac", Magic: []byte("fLaC")},
+	{MIME: "audio/amr", Magic: []byte("#!AMR")},
+	{MIME: "video/x-flv", Magic: []byte("FLV\x01")},
+	{MIME: "application/x-xz", Magic: []byte("\xfd7zXZ\x00")},
+	{MIME: "application/x-7z-compressed", Magic: []byte("7z\xbc\xaf\x27\x1c")},
+	{MIME: "application/zstd", Magic: []byte("\x28\xb5\x2f\xfd")},
+	{MIME: "application/x-tar", Offset: 257, Magic: []byte("ustar")},
+	{MIME: "application/vnd.sqlite3", Magic: []byte("SQLite format 3\x00")},
+	{MIME: "application/rtf", Magic: []byte("{\\rtf")},
+	{MIME: "application/x-ole-storage", Magic: []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")},
+	{MIME: "application/x-executable", Magic: []byte("\x7fELF")},
+}
+
+// sniffZip tells apart the formats stored as zip archives. OpenDocument and
+// EPUB files start with an uncompressed "mimetype" entry naming their type;
+// the others are recognised by the names of their first entries.
+func sniffZip(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return ""
+	}
+	for offset := 0; offset+30 <= len(head) && bytes.Equal(head[offset:offset+4], []byte("PK\x03\x04")); {
+		flags := binary.LittleEndian.Uint16(head[offset+6:])
+		method := binary.LittleEndian.Uint16(head[offset+8:])
+		compressedSize := int(binary.LittleEndian.Uint32(head[offset+18:]))
+		nameLen := int(binary.LittleEndian.Uint16(head[offset+26:]))
+		extraLen := int(binary.LittleEndian.Uint16(head[offset+28:]))
+		nameEnd := offset + 30 + nameLen
+		if nameEnd > len(head) {
+			break
+		}
+		name := string(head[offset+30 : nameEnd])
+		data := nameEnd + extraLen
+
+		if data > len(head) {
+			break
+		}
+		// entries written by streaming zip writers keep their sizes in a
+		// descriptor after the data, so look for the next signature instead
+		end := data + compressedSize
+		if flags&0x8 != 0 {
+			next := bytes.Index(head[data:], []byte("PK"))
+			if next < 0 {
+				next = len(head) - data
+			}
+			end = data + next
+		}
+
+		if offset == 0 && name == "mimetype" && method == 0 && end <= len(head) {
+			if mimeType := string(head[data:end]); strings.HasPrefix(mimeType, "application/") {
+				return mimeType
+			}
+		}
+		for _, z := range zipEntryTypes {
+			if strings.HasPrefix(name, z.prefix) {
+				return z.mime
+			}
+		}
+		if flags&0x8 != 0 {
+			next := bytes.Index(head[data:], []byte("PK\x03\x04"))
+			if next < 0 {
+				break
+			}
+			end = data + next
+		}
+		offset = end
+	}
+	return "application/zip"
+}
+
+var zipEntryTypes = []struct {
+	prefix string
+	mime   string
+}{
+	{prefix: "word/", mime: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{prefix: "xl/", mime: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{prefix: "ppt/", mime: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{prefix: "AndroidManifest.xml", mime: "application/vnd.android.package-archive"},
+	{prefix: "META-INF/MANIFEST.MF", mime: "application/java-archive"},
+}
+
+// sniffISOMedia reads the brands of an ISO base media file (MP4, QuickTime,
+// HEIF and friends). The major brand decides unless a compatible brand
+// names an image format.
+func sniffISOMedia(head []byte) string {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+	boxSize := int(binary.BigEndian.Uint32(head))
+	if boxSize < 16 || boxSize > len(head) {
+		boxSize = len(head)
+	}
+	major := string(head[8:12])
+	var compatible []string
+	for i := 16; i+4 <= boxSize; i += 4 {
+		compatible = append(compatible, string(head[i:i+4]))
+	}
+	for _, brand := range append([]string{major}, compatible...) {
+		switch brand {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return "image/heic"
+		}
+	}
+	switch major {
+	case "mif1", "msf1":
+		return "image/heif"
+	case "M4A ", "M4B ", "M4P ", "F4A ", "F4B ":
+		return "audio/mp4"
+	case "M4V ", "M4VH", "M4VP":
+		return "video/x-m4v"
+	case "qt  ":
+		return "video/quicktime"
+	case "crx ":
+		return "image/x-canon-cr3"
+	}
+	if strings.HasPrefix(major, "3g2") {
+		return "video/3gpp2"
+	}
+	if strings.HasPrefix(major, "3gp") {
+		return "video/3gpp"
+	}
+	return "video/mp4"
+}
+
+// sniffOgg names the codec of the first stream in an Ogg file.
+func sniffOgg(head []byte) string {
+	if len(head) < 28 || !bytes.HasPrefix(head, []byte("OggS\x00")) {
+		return ""
+	}
+	packet := head[28:]
+	switch {
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		return "audio/ogg"
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		return "audio/ogg"
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		return "audio/ogg"
+	case bytes.HasPrefix(packet, []byte("\x80theora")):
+		return "video/ogg"
+	}
+	return "application/ogg"
+}
+
+// sniffMatroska separates Matroska files from WebM, which share the EBML
+// header and differ in its DocType.
+func sniffMatroska(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")) {
+		return ""
+	}
+	limit := head
+	if len(limit) > 64 {
+		limit = limit[:64]
+	}
+	if bytes.Contains(limit, []byte("matroska")) {
+		return "video/x-matroska"
+	}
+	return "video/webm"
+}
+
+// sniffPE recognises Windows executables by the PE signature the DOS header
+// points to, as "MZ" alone also starts ordinary text.
+func sniffPE(head []byte) string {
+	if len(head) < 0x40 || !bytes.HasPrefix(head, []byte("MZ")) {
+		return ""
+	}
+	offset := binary.LittleEndian.Uint32(head[0x3c:])
+	if uint64(offset)+4 > uint64(len(head)) || !bytes.Equal(head[offset:offset+4], []byte("PE\x00\x00")) {
+		return ""
+	}
+	return "application/vnd.microsoft.portable-executable"
+}
+
+// sniffBzip2 recognises bzip2 streams by the block size after "BZh" and the
+// magic of the first block, or of the end of an empty stream.
+func sniffBzip2(head []byte) string {
+	if len(head) < 10 || !bytes.HasPrefix(head, []byte("BZh")) || head[3] < '1' || head[3] > '9' {
+		return ""
+	}
+	if !bytes.HasPrefix(head[4:], []byte("1AY&SY")) && !bytes.HasPrefix(head[4:], []byte("\x17\x72\x45\x38\x50\x90")) {
+		return ""
+	}
+	return "application/x-bzip2"
+}
+
+// isSVG reports whether a text file starts with an svg element, once any XML
+// declaration, comments and doctype are skipped. SVG files can hold scripts,
+// so they must not pass as plain text or XML.
+func isSVG(head []byte) bool {
+	rest := bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	for {
+		rest = bytes.TrimLeft(rest, " \t\r\n")
+		if !bytes.HasPrefix(rest, []byte("<?")) && !bytes.HasPrefix(rest, []byte("<!")) {
+			break
+		}
+		end := []byte(">")
+		if bytes.HasPrefix(rest, []byte("<!--")) {
+			end = []byte("-->")
+		}
+		i := bytes.Index(rest, end)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(end):]
+	}
+	return len(rest) > 4 && bytes.EqualFold(rest[:4], []byte("<svg")) && strings.ContainsRune(" \t\r\n>/", rune(rest[4]))
+}
+
+// sniffMPEGAudio recognises MP3 and AAC (ADTS) streams without an ID3 tag by
+// their frame header.
+func sniffMPEGAudio(head []byte) string {
+	if len(head) < 4 || head[0] != 0xff || head[1]&0xe0 != 0xe0 {
+		return ""
+	}
+	layer := head[1] >> 1 & 0x3
+	if layer == 0 {
+		// ADTS uses the MPEG-2 and MPEG-4 sync word with layer 0
+		if head[1]&0xf6 == 0xf0 && head[2]>>2&0xf < 13 {
+			return "audio/aac"
+		}
+		return ""
+	}
+	version := head[1] >> 3 & 0x3
+	bitrate := head[2] >> 4
+	sampleRate := head[2] >> 2 & 0x3
+	if version == 1 || bitrate == 0xf || sampleRate == 0x3 {
+		return ""
+	}
+	return "audio/mpeg"
+}
+
+// typeExtensions maps the types the built-in sniffer reports to their file
+// extensions.
+var typeExtensions = map[string][]string{
+	"application/epub+zip":                            {".epub"},
+	"application/gzip":                                {".gz"},
+	"application/java-archive":                        {".jar"},
+	"application/json":                                {".json"},
+	"application/msword":                              {".doc"},
+	"application/ogg":                                 {".ogg", ".ogx"},
+	"application/pdf":                                 {".pdf"},
+	"application/postscript":                          {".ps", ".eps"},
+	"application/rtf":                                 {".rtf"},
+	"application/vnd.android.package-archive":         {".apk"},
+	"application/vnd.microsoft.portable-executable":   {".exe", ".dll"},
+	"application/vnd.ms-excel":                        {".xls"},
+	"application/vnd.ms-fontobject":                   {".eot"},
+	"application/vnd.ms-outlook":                      {".msg"},
+	"application/vnd.ms-powerpoint":                   {".ppt"},
+	"application/vnd.oasis.opendocument.formula":      {".odf"},
+	"application/vnd.oasis.opendocument.graphics":     {".odg"},
+	"application/vnd.oasis.opendocument.presentation": {".odp"},
+	"application/vnd.oasis.opendocument.spreadsheet":  {".ods"},
+	"application/vnd.oasis.opendocument.text":         {".odt"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {".pptx"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {".xlsx"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {".docx"},
+	"application/vnd.rar":          {".rar"},
+	"application/vnd.sqlite3":      {".sqlite", ".db"},
+	"application/wasm":             {".wasm"},
+	"application/x-7z-compressed":  {".7z"},
+	"application/x-bzip2":          {".bz2"},
+	"application/x-gzip":           {".gz"},
+	"application/x-ole-storage":    {".doc", ".xls", ".ppt", ".msg"},
+	"application/x-rar-compressed": {".rar"},
+	"application/x-tar":            {".tar"},
+	"application/x-xz":             {".xz"},
+	"application/xhtml+xml":        {".xhtml"},
+	"application/yaml":             {".yaml", ".yml"},
+	"application/zip":              {".zip"},
+	"application/zstd":             {".zst"},
+	"audio/aac":                    {".aac"},
+	"audio/aiff":                   {".aiff", ".aif"},
+	"audio/amr":                    {".amr"},
+	"audio/basic":                  {".au", ".snd"},
+	"audio/flac":                   {".flac"},
+	"audio/midi":                   {".mid", ".midi"},
+	"audio/mp4":                    {".m4a", ".m4b"},
+	"audio/mpeg":                   {".mp3"},
+	"audio/ogg":                    {".ogg", ".oga", ".opus"},
+	"audio/wave":                   {".wav"},
+	"audio/webm":                   {".weba"},
+	"font/collection":              {".ttc"},
+	"font/otf":                     {".otf"},
+	"font/ttf":                     {".ttf"},
+	"font/woff":                    {".woff"},
+	"font/woff2":                   {".woff2"},
+	"image/avif":                   {".avif"},
+	"image/bmp":                    {".bmp"},
+	"image/gif":                    {".gif"},
+	"image/heic":                   {".heic"},
+	"image/heif":                   {".heif"},
+	"image/jpeg":                   {".jpg", ".jpeg"},
+	"image/jxl":                    {".jxl"},
+	"image/png":                    {".png"},
+	"image/svg+xml":                {".svg"},
+	"image/tiff":                   {".tif", ".tiff"},
+	"image/vnd.adobe.photoshop":    {".psd"},
+	"image/webp":                   {".webp"},
+	"image/x-canon-cr3":            {".cr3"},
+	"image/x-icon":                 {".ico", ".cur"},
+	"text/calendar":                {".ics"},
+	"text/css":                     {".css"},
+	"text/csv":                     {".csv"},
+	"text/html":                    {".html", ".htm"},
+	"text/javascript":              {".js", ".mjs"},
+	"text/markdown":                {".md", ".markdown"},
+	"text/plain":                   {".txt"},
+	"text/tab-separated-values":    {".tsv"},
+	"text/vcard":                   {".vcf"},
+	"text/xml":                     {".xml"},
+	"video/3gpp":                   {".3gp"},
+	"video/3gpp2":                  {".3g2"},
+	"video/avi":                    {".avi"},
+	"video/mp4":                    {".mp4"},
+	"video/ogg":                    {".ogv"},
+	"video/quicktime":              {".mov"},
+	"video/webm":                   {".webm"},
+	"video/x-flv":                  {".flv"},
+	"video/x-m4v":                  {".m4v"},
+	"video/x-matroska":             {".mkv", ".mka"},
+}
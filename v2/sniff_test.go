@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestZip builds a zip archive with the named entries. An entry called
+// "mimetype" is stored uncompressed, as OpenDocument and EPUB require.
+func newTestZip(t *testing.T, entries ...string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, entry := range entries {
+		name, content, _ := strings.Cut(entry, "=")
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		if name == "mimetype" {
+			hdr.Method = zip.Store
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "mimetype" {
+			content += strings.Repeat(" ", 200)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+// newTestRawZip builds a zip archive of stored entries with their sizes in
+// the local headers, the way office suites write them.
+func newTestRawZip(t *testing.T, entries ...string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, entry := range entries {
+		name, content, _ := strings.Cut(entry, "=")
+		size := uint64(len(content))
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: name, Method: zip.Store, CRC32: crc32.ChecksumIEEE([]byte(content)), CompressedSize64: size, UncompressedSize64: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func ftyp(major string, compatible ...string) []byte {
+	box := []byte("\x00\x00\x00\x00ftyp" + major + "\x00\x00\x00\x00" + strings.Join(compatible, ""))
+	box[3] = byte(len(box))
+	return append(box, make([]byte, 32)...)
+}
+
+func TestSignatureSniffer_Sniff(t *testing.T) {
+	sniffTests := []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{name: "docx", head: newTestZip(t, "[Content_Types].xml=<Types/>", "_rels/.rels=<Relationships/>", "word/document.xml=<w:document/>"), expected: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "xlsx", head: newTestZip(t, "[Content_Types].xml=<Types/>", "xl/workbook.xml=<workbook/>"), expected: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{name: "pptx", head: newTestZip(t, "[Content_Types].xml=<Types/>", "ppt/presentation.xml=<p/>"), expected: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+		{name: "odt", head: newTestZip(t, "mimetype=application/vnd.oasis.opendocument.text", "content.xml=<office/>")[:200], expected: "application/vnd.oasis.opendocument.text"},
+		{name: "ods with sizes", head: newTestRawZip(t, "mimetype=application/vnd.oasis.opendocument.spreadsheet", "content.xml=<office/>"), expected: "application/vnd.oasis.opendocument.spreadsheet"},
+		{name: "xlsx with sizes", head: newTestRawZip(t, "[Content_Types].xml=<Types/>", "_rels/.rels=<Relationships/>", "xl/workbook.xml=<workbook/>"), expected: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{name: "epub", head: newTestZip(t, "mimetype=application/epub+zip", "META-INF/container.xml=<container/>"), expected: "application/epub+zip"},
+		{name: "jar", head: newTestZip(t, "META-INF/MANIFEST.MF=Manifest-Version: 1.0"), expected: "application/java-archive"},
+		{name: "plain zip", head: newTestZip(t, "a.txt=hello", "b.txt=world"), expected: "application/zip"},
+		{name: "heic", head: ftyp("heic", "mif1", "heic"), expected: "image/heic"},
+		{name: "heic by compatible brand", head: ftyp("mif1", "mif1", "heic"), expected: "image/heic"},
+		{name: "heif", head: ftyp("mif1", "mif1"), expected: "image/heif"},
+		{name: "avif", head: ftyp("avif", "avif", "mif1"), expected: "image/avif"},
+		{name: "m4a", head: ftyp("M4A ", "M4A ", "mp42", "isom"), expected: "audio/mp4"},
+		{name: "quicktime", head: ftyp("qt  ", "qt  "), expected: "video/quicktime"},
+		{name: "3gp", head: ftyp("3gp5", "3gp5", "isom"), expected: "video/3gpp"},
+		{name: "mp4", head: ftyp("isom", "isom", "iso2", "avc1", "mp41"), expected: "video/mp4"},
+		{name: "webp lossless", head: []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00"), expected: "image/webp"},
+		{name: "tiff", head: []byte("II*\x00\x08\x00\x00\x00"), expected: "image/tiff"},
+		{name: "opus", head: append([]byte("OggS\x00\x02"+strings.Repeat("\x00", 22)), "OpusHead"...), expected: "audio/ogg"},
+		{name: "theora", head: append([]byte("OggS\x00\x02"+strings.Repeat("\x00", 22)), "\x80theora"...), expected: "video/ogg"},
+		{name: "matroska", head: []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska"), expected: "video/x-matroska"},
+		{name: "webm", head: []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), expected: "video/webm"},
+		{name: "flac", head: []byte("fLaC\x00\x00\x00\x22"), expected: "audio/flac"},
+		{name: "mp3 without tag", head: []byte("\xff\xfb\x90\x64\x00\x00"), expected: "audio/mpeg"},
+		{name: "utf-16le text", head: []byte("\xff\xfeh\x00e\x00l\x00l\x00o\x00"), expected: "text/plain; charset=utf-16le"},
+		{name: "utf-16be text", head: []byte("\xfe\xff\x00h\x00e\x00l\x00l\x00o"), expected: "text/plain; charset=utf-16be"},
+		{name: "aac", head: []byte("\xff\xf1\x50\x80\x02\x1f\xfc"), expected: "audio/aac"},
+		{name: "png falls back", head: []byte("\x89PNG\x0d\x0a\x1a\x0a\x00\x00"), expected: "image/png"},
+		{name: "pe", head: append(append([]byte("MZ"), make([]byte, 0x3a)...), "\x40\x00\x00\x00PE\x00\x00"...), expected: "application/vnd.microsoft.portable-executable"},
+		{name: "text starting with MZ", head: []byte("MZ corp quarterly report"), expected: "text/plain; charset=utf-8"},
+		{name: "bzip2", head: []byte("BZh91AY&SY\x00\x00"), expected: "application/x-bzip2"},
+		{name: "text starting with BZh", head: []byte("BZh some notes"), expected: "text/plain; charset=utf-8"},
+		{name: "text falls back", head: []byte("hello"), expected: "text/plain; charset=utf-8"},
+	}
+
+	sniffer := &SignatureSniffer{}
+	for _, e := range sniffTests {
+		if got := sniffer.Sniff(e.head); got != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+
+	custom := &SignatureSniffer{Signatures: []Signature{{MIME: "application/x-acme", Extensions: []string{".acme"}, Magic: []byte("ACME")}}}
+	if got := custom.Sniff([]byte("ACME\x01")); got != "application/x-acme" {
+		t.Errorf("expected the custom signature to match but got %s", got)
+	}
+	if exts := custom.Extensions("application/x-acme"); len(exts) != 1 || exts[0] != ".acme" {
+		t.Errorf("unexpected extensions %v", exts)
+	}
+}
+
+var fileTypeAllowedTests = []struct {
+	name     string
+	allowed  []string
+	fileType string
+	expected bool
+}{
+	{name: "anything", allowed: nil, fileType: "application/zip", expected: true},
+	{name: "exact", allowed: []string{"image/png"}, fileType: "image/png", expected: true},
+	{name: "case", allowed: []string{"IMAGE/PNG"}, fileType: "image/png", expected: true},
+	{name: "with parameters", allowed: []string{"text/plain; charset=utf-8"}, fileType: "text/plain; charset=utf-8", expected: true},
+	{name: "without parameters", allowed: []string{"text/plain"}, fileType: "text/plain; charset=utf-8", expected: true},
+	{name: "family", allowed: []string{"image/*"}, fileType: "image/heic", expected: true},
+	{name: "other family", allowed: []string{"image/*"}, fileType: "video/mp4", expected: false},
+	{name: "family is not a prefix", allowed: []string{"image/*"}, fileType: "imagex/png", expected: false},
+	{name: "extension", allowed: []string{".docx"}, fileType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", expected: true},
+	{name: "second extension", allowed: []string{".JPEG"}, fileType: "image/jpeg", expected: true},
+	{name: "wrong extension", allowed: []string{".docx"}, fileType: "application/zip", expected: false},
+	{name: "not allowed", allowed: []string{"image/png", ".pdf"}, fileType: "text/html; charset=utf-8", expected: false},
+}
+
+func TestTools_FileTypeAllowed(t *testing.T) {
+	for _, e := range fileTypeAllowedTests {
+		testTools := Tools{AllowedFileType: e.allowed}
+		if got := testTools.fileTypeAllowed(e.fileType); got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_UploadOfficeDocument(t *testing.T) {
+	testTools := Tools{Storage: NewMemoryStorage(), AllowedFileType: []string{".docx", ".xlsx"}, StreamUploads: true}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "report.docx")
+	part.Write(newTestZip(t, "[Content_Types].xml=<Types/>", "_rels/.rels=<Relationships/>", "word/document.xml=<w:document/>"))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if _, err := testTools.UploadOneFile(req, "uploads"); err != nil {
+		t.Errorf("expected the document to be accepted but got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is where uploaded files are written to and downloads are read from.
+// Keys are slash separated paths. Implementations must report missing objects
+// with an error matching fs.ErrNotExist, and must not leave a partial object
+// behind or touch an existing object under the key when the reader handed to
+// Put fails.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (*ObjectInfo, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]*ObjectInfo, error)
+}
+
+// Renamer is implemented by storages that can move an object to a new key
+// without copying it.
+type Renamer interface {
+	Rename(ctx context.Context, from, to string) error
+}
+
+type ObjectInfo struct {
+	Key string
+	// Bucket is set by storages that keep objects in buckets.
+	Bucket      string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	ETag        string
+}
+
+func (t *Tools) storage() Storage {
+	if t.Storage == nil {
+		return &DiskStorage{}
+	}
+	return t.Storage
+}
+
+// DiskStorage keeps objects as files below Root. With an empty Root keys are
+// treated as paths relative to the working directory.
+type DiskStorage struct {
+	Root string
+}
+
+// path maps key to a file path, keeping it inside Root when one is set.
+func (s *DiskStorage) path(key string) string {
+	if s.Root == "" {
+		return filepath.FromSlash(key)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put writes to a temporary file next to the target and renames it into place
+// once everything arrived, so a failed Put leaves an existing file intact.
+func (s *DiskStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (*ObjectInfo, error) {
+	fp := s.path(key)
+	const mode = 0755
+	if err := os.MkdirAll(filepath.Dir(fp), mode); err != nil {
+		return nil, err
+	}
+	outfile, err := os.CreateTemp(filepath.Dir(fp), "."+filepath.Base(fp)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(outfile, r)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		// CreateTemp makes files only the owner can read
+		err = outfile.Chmod(0644)
+	}
+	if cerr := outfile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(outfile.Name(), fp)
+	}
+	if err != nil {
+		os.Remove(outfile.Name())
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: size, ModTime: time.Now(), ContentType: contentType}, nil
+}
+
+func (s *DiskStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	fp := s.path(key)
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	return f, fileObjectInfo(key, fi), nil
+}
+
+func (s *DiskStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fp := s.path(key)
+	fi, err := os.Stat(fp)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return fileObjectInfo(key, fi), nil
+}
+
+func (s *DiskStorage) Delete(ctx context.Context, key string) error {
+	return os.Remove(s.path(key))
+}
+
+func (s *DiskStorage) Rename(ctx context.Context, from, to string) error {
+	dst := s.path(to)
+	const mode = 0755
+	if err := os.MkdirAll(filepath.Dir(dst), mode); err != nil {
+		return err
+	}
+	return os.Rename(s.path(from), dst)
+}
+
+func (s *DiskStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	dir := "."
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+	root := s.path(dir)
+	prefix = cleanPrefix(prefix)
+	var objects []*ObjectInfo
+	err := filepath.WalkDir(root, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return err
+		}
+		key := path.Join(dir, filepath.ToSlash(rel))
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileObjectInfo(key, fi))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// cleanPrefix cleans prefix the way path.Join cleans keys, keeping a trailing
+// slash so that "a/" does not match "ab".
+func cleanPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	clean := path.Clean(prefix)
+	if strings.HasSuffix(prefix, "/") && clean != "/" {
+		clean += "/"
+	}
+	if clean == "./" || clean == "." {
+		return ""
+	}
+	return clean
+}
+
+func fileObjectInfo(key string, fi fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}
+}
+
+// MemoryStorage keeps objects in memory. It is meant for tests.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string]*memoryObject)}
+}
+
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (*ObjectInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	obj := &memoryObject{
+		data: data,
+		info: ObjectInfo{Key: key, Size: int64(len(data)), ModTime: time.Now(), ContentType: contentType},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = make(map[string]*memoryObject)
+	}
+	s.objects[key] = obj
+	info := obj.info
+	return &info, nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	info := obj.info
+	return readSeekNopCloser{bytes.NewReader(obj.data)}, &info, nil
+}
+
+func (s *MemoryStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	info := obj.info
+	return &info, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: key, Err: fs.ErrNotExist}
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStorage) Rename(ctx context.Context, from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[from]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+	delete(s.objects, from)
+	obj.info.Key = to
+	s.objects[to] = obj
+	return nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var objects []*ObjectInfo
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			info := obj.info
+			objects = append(objects, &info)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
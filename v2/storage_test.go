@@ -0,0 +1,145 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var storageTests = []struct {
+	name    string
+	storage Storage
+}{
+	{name: "disk", storage: &DiskStorage{Root: "./testdata/storage"}},
+	{name: "disk without root", storage: &DiskStorage{}},
+	{name: "memory", storage: NewMemoryStorage()},
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	for _, e := range storageTests {
+		prefix := "uploads/"
+		if e.name == "disk without root" {
+			prefix = "testdata/storage/uploads/"
+		}
+		for _, key := range []string{"a.txt", "b.txt", "nested/c.txt"} {
+			if _, err := e.storage.Put(ctx, prefix+key, strings.NewReader("hello "+key), "text/plain"); err != nil {
+				t.Errorf("%s: put %s: %s", e.name, key, err)
+			}
+		}
+		e.storage.Put(ctx, "other/d.txt", strings.NewReader("d"), "text/plain")
+
+		info, err := e.storage.Stat(ctx, prefix+"a.txt")
+		if err != nil {
+			t.Errorf("%s: stat: %s", e.name, err)
+		} else if info.Size != int64(len("hello a.txt")) {
+			t.Errorf("%s: wrong size %d", e.name, info.Size)
+		}
+
+		rc, _, err := e.storage.Get(ctx, prefix+"nested/c.txt")
+		if err != nil {
+			t.Errorf("%s: get: %s", e.name, err)
+		} else {
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "hello nested/c.txt" {
+				t.Errorf("%s: wrong content %q", e.name, data)
+			}
+		}
+
+		objects, err := e.storage.List(ctx, prefix)
+		if err != nil {
+			t.Errorf("%s: list: %s", e.name, err)
+		}
+		if len(objects) != 3 {
+			t.Errorf("%s: expected 3 objects but got %d", e.name, len(objects))
+		}
+
+		_, err = e.storage.Put(ctx, prefix+"broken.txt", io.MultiReader(strings.NewReader("partial"), &errReader{}), "")
+		if err == nil {
+			t.Errorf("%s: expected put to fail", e.name)
+		}
+		if _, err := e.storage.Stat(ctx, prefix+"broken.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected partial object to be removed", e.name)
+		}
+
+		// a failed overwrite keeps the old object
+		_, err = e.storage.Put(ctx, prefix+"a.txt", io.MultiReader(strings.NewReader("partial"), &errReader{}), "")
+		if err == nil {
+			t.Errorf("%s: expected put to fail", e.name)
+		}
+		if rc, _, err := e.storage.Get(ctx, prefix+"a.txt"); err != nil {
+			t.Errorf("%s: expected the old object to survive a failed put: %s", e.name, err)
+		} else {
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "hello a.txt" {
+				t.Errorf("%s: old object changed to %q", e.name, data)
+			}
+		}
+		if objects, _ := e.storage.List(ctx, prefix); len(objects) != 3 {
+			t.Errorf("%s: expected failed puts to leave nothing behind but got %d objects", e.name, len(objects))
+		}
+
+		for _, o := range objects {
+			if err := e.storage.Delete(ctx, o.Key); err != nil {
+				t.Errorf("%s: delete: %s", e.name, err)
+			}
+		}
+		if _, err := e.storage.Stat(ctx, prefix+"a.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected not exist error but got %v", e.name, err)
+		}
+		e.storage.Delete(ctx, "other/d.txt")
+	}
+	//cleanup
+	os.RemoveAll("./testdata/storage")
+	os.RemoveAll("./other")
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestTools_UploadToStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	testTools := Tools{Storage: storage, StreamUploads: true}
+	uploadedFiles, err := testTools.UploadFiles(newUploadRequest(t, "a.png"), "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := storage.Stat(context.Background(), "uploads/"+uploadedFiles[0].NewFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "image/png" || info.Size != uploadedFiles[0].FileSize {
+		t.Errorf("unexpected object info %+v", info)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	testTools.DownloadStoredFile(rr, req, info.Key, "picture.png")
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 but got %d", rr.Code)
+	}
+	if rr.Body.Len() != int(info.Size) || !bytes.HasPrefix(rr.Body.Bytes(), []byte("\x89PNG")) {
+		t.Error("wrong body served")
+	}
+	if rr.Header().Get("Content-Disposition") != `attachment; filename="picture.png"` {
+		t.Error("wrong content-disposition", rr.Header().Get("Content-Disposition"))
+	}
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadStoredFile(rr, req, "uploads/missing.png", "missing.png")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 but got %d", rr.Code)
+	}
+}
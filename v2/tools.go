@@ -2,15 +2,18 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -19,6 +22,48 @@ type Tools struct {
 	AllowedFileType    []string
 	MaxJSONSize        int
 	AllowUnknownFields bool
+	// StreamUploads makes UploadFiles read parts straight from the request
+	// body instead of parsing the whole multipart form first.
+	StreamUploads bool
+	// MaxUploadSize limits the size of a whole streamed upload request.
+	MaxUploadSize int
+	// Storage receives uploaded files and serves DownloadStoredFile. Files
+	// go to the local disk when it is nil.
+	Storage Storage
+	// AllOrNothing removes every file saved by an upload if any file in it
+	// is rejected or fails.
+	AllOrNothing bool
+	// ProblemJSON makes ErrorJson write RFC 7807 application/problem+json
+	// responses instead of JSONResponse.
+	ProblemJSON bool
+	// Logger receives upload, JSON and remote call events. Nothing is
+	// logged when it is nil.
+	Logger Logger
+	// Deduplicate stores uploads under the SHA-256 of their content, so
+	// identical files are kept once. File names are then ignored. Content
+	// is never deleted when uploads are rolled back, since it may be shared.
+	Deduplicate bool
+	// FileNameCollision decides what happens when an upload that keeps its
+	// file name meets an existing file. Existing files are overwritten by
+	// default.
+	FileNameCollision CollisionPolicy
+	// Quotas, when set, limits how much every identity may upload.
+	// Identity tells who an upload request belongs to; without it all
+	// uploads share one quota. QuotaLimit returns the limits of an identity
+	// and may be nil to only track usage.
+	Quotas     QuotaStore
+	Identity   func(r *http.Request) string
+	QuotaLimit func(identity string) Quota
+	// Sniffer detects the type of uploaded files for AllowedFileType. It
+	// defaults to a SignatureSniffer.
+	Sniffer ContentSniffer
+	// TypeMismatch decides what happens to uploads whose extension or
+	// Content-Type disagrees with their content. Nothing is checked by
+	// default.
+	TypeMismatch TypeMismatchPolicy
+	// Images limits the dimensions of uploaded images and can re-encode them
+	// to strip metadata. Images are not inspected by default.
+	Images ImagePolicy
 }
 
 const randomSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_+"
@@ -37,6 +82,21 @@ type UploadedFile struct {
 	NewFileName      string
 	OriginalFileName string
 	FileSize         int64
+	// Key, Bucket and ETag locate the file in Storage.
+	Key    string
+	Bucket string
+	ETag   string
+	// SHA256 is the hex encoded digest of the content, set when
+	// Deduplicate is on. Duplicate reports that the content was already
+	// stored before this upload.
+	SHA256    string
+	Duplicate bool
+	// Width and Height are set for images checked by Tools.Images.
+	Width  int
+	Height int
+	// Renditions lists the renditions stored for the image, in the order of
+	// Tools.Images.Renditions.
+	Renditions []RenditionFile
 }
 
 func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
@@ -57,78 +117,17 @@ func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (
 		renameFile = rename[0]
 	}
 
-	var uploadedFiles []*UploadedFile
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024
-	}
-
-	err := t.CreateDirIfNotExist(uploadDir)
+	results, err := t.upload(r, uploadDir, renameFile, true)
 	if err != nil {
 		return nil, err
 	}
-
-	//Validate file size is within permitted value
-	err = r.ParseMultipartForm(int64(t.MaxFileSize))
-	if err != nil {
-		return nil, errors.New("Uploaded file size if too big")
-	}
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
-				buff := make([]byte, 512)
-				_, err = infile.Read(buff)
-				if err != nil {
-					return nil, err
-				}
-				allowed := false
-				fileType := http.DetectContentType(buff)
-				//validate file type is permitted
-				if len(t.AllowedFileType) > 0 {
-					for _, e := range t.AllowedFileType {
-						if strings.EqualFold(fileType, e) {
-							allowed = true
-						}
-					}
-				} else {
-					allowed = true
-				}
-				if !allowed {
-					return nil, errors.New("this file type is not permitted")
-				}
-				_, err = infile.Seek(0, 0)
-				if err != nil {
-					return nil, err
-				}
-				uploadedFile.OriginalFileName = hdr.Filename
-				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(hdr.Filename))
-				} else {
-					uploadedFile.NewFileName = hdr.Filename
-				}
-				var outfile *os.File
-				defer outfile.Close()
-				if outfile, err := os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-				return uploadedFiles, nil
-			}(uploadedFiles)
-			if err != nil {
-				return uploadedFiles, err
-			}
+	var uploadedFiles []*UploadedFile
+	for _, result := range results {
+		// files removed by AllOrNothing come before the one that failed
+		if result.Err != nil && result.Err != ErrUploadRolledBack {
+			return nil, result.Err
 		}
+		uploadedFiles = append(uploadedFiles, result.File)
 	}
 	return uploadedFiles, nil
 }
@@ -146,34 +145,119 @@ func (t *Tools) CreateDirIfNotExist(path string) error {
 
 func (t *Tools) Slugify(s string) (string, error) {
 	if len(s) == 0 {
-		return "", errors.New("Empty string received")
+		return "", &detailedError{ErrEmptySlug, "Empty string received"}
 	}
 	re := regexp.MustCompile(`[^a-z\d]+`)
 	slug := strings.Trim(re.ReplaceAllString(strings.ToLower(s), "-"), "-")
 	if len(slug) == 0 {
-		return "", errors.New("slug length empty after removing characters")
+		return "", &detailedError{ErrEmptySlug, "slug length empty after removing characters"}
 	}
 	return slug, nil
 }
 
 func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, pathName, displayName string) {
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename\"%s\"", displayName))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", displayName))
 	http.ServeFile(w, r, pathName)
 }
 
+// DownloadStoredFile serves the object stored under key as an attachment.
+func (t *Tools) DownloadStoredFile(w http.ResponseWriter, r *http.Request, key, displayName string, opts ...DownloadOption) {
+	o := newDownloadOptions(opts)
+	rc, info, err := t.storage().Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Disposition", contentDisposition(o.disposition, displayName))
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), info.ModTime, rs)
+		return
+	}
+	if info.ContentType == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, rc)
+}
+
 type JSONResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 }
 
-func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
-	maxBytes := 1024 * 1024
-	if t.MaxFileSize != 0 {
-		maxBytes = t.MaxFileSize
+const defaultMaxJSONSize = 1024 * 1024
+
+// JSONOption changes how a single ReadJSON call decodes its body.
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	maxBytes int
+	verifier *WebhookSigner
+}
+
+// WithMaxJSONSize overrides MaxJSONSize for one call.
+func WithMaxJSONSize(n int) JSONOption {
+	return func(o *jsonOptions) {
+		o.maxBytes = n
+	}
+}
+
+// ReadJSON decodes a single JSON value from the body of r into data. The
+// body is limited to MaxJSONSize bytes, 1 MiB unless set.
+func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}, opts ...JSONOption) error {
+	err := t.readJSON(w, r, data, opts...)
+	if err != nil {
+		t.logger().Warn("json decode failed", append(requestAttrs(r), "error", err, "error_kind", errorKind(err), "status", ErrorStatus(err))...)
+	}
+	return err
+}
+
+func (t *Tools) readJSON(w http.ResponseWriter, r *http.Request, data interface{}, opts ...JSONOption) error {
+	o := jsonOptions{maxBytes: t.MaxJSONSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	maxBytes := defaultMaxJSONSize
+	if o.maxBytes > 0 {
+		maxBytes = o.maxBytes
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	dec := json.NewDecoder(r.Body)
+	var body io.Reader = r.Body
+	if o.verifier != nil {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return &detailedError{ErrBodyTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+			}
+			return err
+		}
+		if err := o.verifier.Verify(r.Header, payload); err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+	err := t.decodeJSON(body, data, maxBytes)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	return nil
+}
+
+// decodeJSON decodes exactly one JSON value from body, which must already be
+// limited to maxBytes.
+func (t *Tools) decodeJSON(body io.Reader, data interface{}, maxBytes int) error {
+	dec := json.NewDecoder(body)
 	if !t.AllowUnknownFields {
 		dec.DisallowUnknownFields()
 	}
@@ -182,36 +266,32 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly formatted json (at char %d)", syntaxError.Offset)
-
+			return &JSONDecodeError{Kind: JSONSyntax, Offset: syntaxError.Offset, Err: err}
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly formatted json")
+			return &JSONDecodeError{Kind: JSONSyntax, Err: err}
 		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect json type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect json type (at char %d)", unmarshalTypeError.Offset)
+			return &JSONDecodeError{Kind: JSONType, Field: unmarshalTypeError.Field, Offset: unmarshalTypeError.Offset, Err: err}
 		case errors.Is(err, io.EOF):
-			return fmt.Errorf("body must not be empty")
+			return &JSONDecodeError{Kind: JSONEmptyBody, Err: err}
 		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return &JSONDecodeError{Kind: JSONUnknownField, Field: strings.Trim(fieldName, `"`), Offset: dec.InputOffset(), Err: err}
+		case errors.As(err, &maxBytesError):
+			return &detailedError{ErrBodyTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
 		case errors.As(err, &invalidUnmarshalError):
-			return fmt.Errorf("error unmarshalling json %s", err.Error())
+			return &JSONDecodeError{Kind: JSONInvalidTarget, Err: err}
 		default:
 			return err
 		}
 	}
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must contain only one JSON value")
+		return &JSONDecodeError{Kind: JSONMultipleValues, Offset: dec.InputOffset(), Err: err}
 	}
-	r.Body.Close()
 	return nil
 }
 
@@ -234,34 +314,34 @@ func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, h
 	return nil
 }
 
+// ErrorJson writes err as a JSONResponse, or as a Problem when ProblemJSON is
+// set. Without an explicit status the code is derived from err by ErrorStatus.
 func (t *Tools) ErrorJson(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+	if t.ProblemJSON {
+		return t.ErrorProblem(w, err, status...)
+	}
+	statusCode := ErrorStatus(err)
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 	var payload JSONResponse
 	payload.Error = true
 	payload.Message = err.Error()
-	fmt.Printf("%+v\n", payload)
+	t.logger().Debug("error response", "status", statusCode, "error", err, "error_kind", errorKind(err))
 	return t.WriteJSON(w, statusCode, payload)
 }
 
+// PushJSONTORemote posts data as JSON to uri. The body of the returned
+// response has already been closed; use PushJSON to read it.
 func (t *Tools) PushJSONTORemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return nil, 0, err
-	}
-	httpClient := &http.Client{}
+	var opts []PushOption
 	if len(client) > 0 {
-		httpClient = client[0]
+		opts = append(opts, WithClient(client[0]))
 	}
-	request, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonData))
-	request.Header.Set("Content-Type", "application/json")
-	res, err := httpClient.Do(request)
-	if len(client) > 0 {
-		httpClient = client[0]
+	res, err := t.PushJSON(context.Background(), uri, data, opts...)
+	if err != nil {
+		return nil, 0, err
 	}
 	defer res.Body.Close()
 	return res, res.StatusCode, nil
-
 }
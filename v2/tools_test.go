@@ -2,6 +2,7 @@ package toolkit
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -123,6 +124,83 @@ func TestTools_Uploads(t *testing.T) {
 	}
 }
 
+// newUploadRequest builds a multipart request holding a file for each of the
+// given names: a generated png for names ending in .png and plain text for
+// anything else.
+func newUploadRequest(t *testing.T, names ...string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range names {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Ext(name) != ".png" {
+			fmt.Fprintf(part, "hello from %s", name)
+			continue
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		rand.Read(img.Pix)
+		if err := png.Encode(part, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.WriteField("note", "not a file")
+	writer.Close()
+	request := httptest.NewRequest("POST", "/", body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+var streamUploadTests = []struct {
+	name          string
+	allowedTypes  []string
+	maxFileSize   int
+	maxUploadSize int
+	errorExpected bool
+	expectedErr   error
+}{
+	{name: "allowed", allowedTypes: []string{"image/png"}, errorExpected: false},
+	{name: "not allowed", allowedTypes: []string{"image/jpeg"}, errorExpected: true, expectedErr: ErrFileTypeNotAllowed},
+	{name: "file too large", maxFileSize: 1024, errorExpected: true, expectedErr: ErrFileTooLarge},
+	{name: "request too large", maxUploadSize: 2048, errorExpected: true, expectedErr: ErrBodyTooLarge},
+}
+
+func TestTools_UploadsStreaming(t *testing.T) {
+	for _, e := range streamUploadTests {
+		testTools := Tools{
+			StreamUploads:   true,
+			AllowedFileType: e.allowedTypes,
+			MaxFileSize:     e.maxFileSize,
+			MaxUploadSize:   e.maxUploadSize,
+		}
+		uploadedFiles, err := testTools.UploadFiles(newUploadRequest(t, "a.png", "b.png"), "./testdata/uploads")
+		if e.errorExpected && err == nil {
+			t.Errorf("%s: error expected but none received", e.name)
+		}
+		if e.expectedErr != nil && !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expectedErr, err)
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: no error expected but got %s", e.name, err.Error())
+		}
+		if !e.errorExpected && len(uploadedFiles) != 2 {
+			t.Errorf("%s: expected 2 files but got %d", e.name, len(uploadedFiles))
+		}
+		for _, f := range uploadedFiles {
+			if _, err := os.Stat(filepath.Join("./testdata/uploads/", f.NewFileName)); err != nil {
+				t.Errorf("%s: expected file to exist:%s", e.name, err.Error())
+			}
+			//cleanup
+			os.Remove(filepath.Join("./testdata/uploads/", f.NewFileName))
+		}
+		entries, _ := os.ReadDir("./testdata/uploads")
+		if len(entries) != 0 {
+			t.Errorf("%s: expected no partial files to be left behind", e.name)
+		}
+	}
+}
+
 func TestTools_UploadOne(t *testing.T) {
 	pr, pw := io.Pipe()
 	defer pr.Close()
@@ -219,7 +297,7 @@ func TestTools_DownloadStaticFile(t *testing.T) {
 		t.Error("Expected the soze to be ", res.Header["Content-Length"][0])
 	}
 	fmt.Println(res.Header["Content-Disposition"][0])
-	if res.Header["Content-Disposition"][0] != "attachment; filename\"love.jpg\"" {
+	if res.Header["Content-Disposition"][0] != "attachment; filename=\"love.jpg\"" {
 		t.Error("wrong content-disposition")
 	}
 }
@@ -247,7 +325,7 @@ var jsonTests = []struct {
 func TestTools_ReadJSON(t *testing.T) {
 	var testTools Tools
 	for _, e := range jsonTests {
-		testTools.MaxFileSize = e.maxSize
+		testTools.MaxJSONSize = e.maxSize
 		testTools.AllowUnknownFields = e.allowedUnknown
 		var decodedJSON struct {
 			Foo string `json:"foo"`
@@ -268,6 +346,41 @@ func TestTools_ReadJSON(t *testing.T) {
 	}
 }
 
+var jsonSizeTests = []struct {
+	name          string
+	maxFileSize   int
+	maxJSONSize   int
+	override      int
+	errorExpected bool
+}{
+	{name: "default limit", errorExpected: false},
+	{name: "max file size is ignored", maxFileSize: 5, errorExpected: false},
+	{name: "max json size", maxFileSize: 1024, maxJSONSize: 5, errorExpected: true},
+	{name: "override raises limit", maxJSONSize: 5, override: 1024, errorExpected: false},
+	{name: "override lowers limit", maxJSONSize: 1024, override: 5, errorExpected: true},
+}
+
+func TestTools_ReadJSONMaxSize(t *testing.T) {
+	for _, e := range jsonSizeTests {
+		testTools := Tools{MaxFileSize: e.maxFileSize, MaxJSONSize: e.maxJSONSize}
+		var opts []JSONOption
+		if e.override > 0 {
+			opts = append(opts, WithMaxJSONSize(e.override))
+		}
+		var decodedJSON struct {
+			Foo string `json:"foo"`
+		}
+		req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &decodedJSON, opts...)
+		if e.errorExpected && !errors.Is(err, ErrBodyTooLarge) {
+			t.Errorf("%s: expected ErrBodyTooLarge but got %v", e.name, err)
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: no error expected but got %s", e.name, err.Error())
+		}
+	}
+}
+
 func TestTools_WriteJSON(t *testing.T) {
 	var testTools Tools
 	rr := httptest.NewRecorder()
@@ -0,0 +1,427 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tusVersion = "1.0.0"
+
+// ResumableUploads is an http.Handler implementing the tus 1.0.0 core
+// protocol with the creation, termination and expiration extensions. Partial
+// uploads are kept on local disk until the last byte arrives; the finished
+// file then goes through the same checks and Storage as UploadFiles.
+//
+// Mount it with its BasePath, e.g.
+//
+//	mux.Handle("/files/", &toolkit.ResumableUploads{Tools: &tools, UploadDir: "uploads", BasePath: "/files/"})
+type ResumableUploads struct {
+	Tools *Tools
+	// UploadDir receives finished files.
+	UploadDir string
+	// PartialDir holds uploads in progress. It defaults to a .tus directory
+	// inside UploadDir.
+	PartialDir string
+	// BasePath is the URL path the handler is mounted at.
+	BasePath string
+	// KeepFileName stores files under the name given in the upload metadata
+	// instead of a random one.
+	KeepFileName bool
+	// OnComplete is called with every finished upload.
+	OnComplete func(r *http.Request, f *UploadedFile)
+	// Expiry is how long an unfinished upload is kept after it was last
+	// written to. It defaults to 24 hours. Clients can no longer resume
+	// expired uploads; call RemoveExpired regularly to free their disk space.
+	Expiry time.Duration
+
+	locks sync.Map
+	now   func() time.Time
+}
+
+// tusInfo is the state of an upload kept next to its partial data.
+type tusInfo struct {
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Identity is who the upload counts against, and Reserved what was
+	// reserved of their quota when it was created.
+	Identity string `json:"identity,omitempty"`
+	Reserved Usage  `json:"reserved"`
+}
+
+func (u *ResumableUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination,expiration")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(u.tools().maxFileSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, u.BasePath), "/")
+	if r.Method == http.MethodPost && id == "" {
+		u.create(w, r)
+		return
+	}
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// look the upload up before taking its lock, so requests for unknown
+	// uploads leave nothing behind
+	if !u.exists(id) {
+		http.NotFound(w, r)
+		return
+	}
+	unlock := u.lock(id)
+	defer unlock()
+
+	info, err := u.info(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		u.tools().ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	if u.expired(id) {
+		u.discard(id, info)
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		u.head(w, id, info)
+	case http.MethodPatch:
+		u.patch(w, r, id, info)
+	case http.MethodDelete:
+		u.discard(id, info)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (u *ResumableUploads) create(w http.ResponseWriter, r *http.Request) {
+	t := u.tools()
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		t.ErrorJson(w, errors.New("a valid Upload-Length header is required"), http.StatusBadRequest)
+		return
+	}
+	if length > t.maxFileSize() {
+		t.ErrorJson(w, ErrFileTooLarge)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		t.ErrorJson(w, err, http.StatusBadRequest)
+		return
+	}
+	// the whole upload is reserved now, so that unfinished uploads cannot
+	// add up to more than the quota
+	info := &tusInfo{Length: length, Metadata: metadata, Identity: t.identity(r)}
+	if t.Quotas != nil {
+		info.Reserved = Usage{Bytes: length, Files: 1}
+	}
+	if err := t.reserveUpfront(r.Context(), info.Identity, info.Reserved); err != nil {
+		t.ErrorJson(w, err)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.releaseUpfront(info.Identity, info.Reserved)
+		t.ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(b)
+	if err := u.writeInfo(id, info); err != nil {
+		t.releaseUpfront(info.Identity, info.Reserved)
+		t.ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", path.Join("/", u.BasePath, id))
+	if length == 0 {
+		u.finish(w, r, id, info, http.StatusCreated)
+		return
+	}
+	u.setExpires(w, id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (u *ResumableUploads) head(w http.ResponseWriter, id string, info *tusInfo) {
+	offset, err := u.offset(id)
+	if err != nil {
+		u.tools().ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *ResumableUploads) patch(w http.ResponseWriter, r *http.Request, id string, info *tusInfo) {
+	t := u.tools()
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := u.offset(id)
+	if err != nil {
+		t.ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	if r.Header.Get("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(u.dataPath(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	remaining := info.Length - offset
+	n, err := io.Copy(f, &maxBytesReader{r: r.Body, n: remaining, err: ErrFileTooLarge})
+	f.Close()
+	offset += n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		// whatever arrived before the connection broke is kept so the
+		// client can resume from the new offset
+		t.logger().Warn("resumable upload interrupted", append(requestAttrs(r), "upload_id", id, "offset", offset, "error", err)...)
+		t.ErrorJson(w, err)
+		return
+	}
+	if offset < info.Length {
+		u.setExpires(w, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	u.finish(w, r, id, info, http.StatusNoContent)
+}
+
+// finish moves a complete upload into Storage and removes its partial data.
+func (u *ResumableUploads) finish(w http.ResponseWriter, r *http.Request, id string, info *tusInfo, status int) {
+	t := u.tools()
+	defer u.remove(id)
+	f, err := os.OpenFile(u.dataPath(id), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.releaseUpfront(info.Identity, info.Reserved)
+		t.ErrorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	header := make(textproto.MIMEHeader)
+	if info.Metadata["filetype"] != "" {
+		header.Set("Content-Type", info.Metadata["filetype"])
+	}
+	part := &filePart{ReadCloser: f, FileName: info.Metadata["filename"], Header: header}
+	uploadedFile, err := t.saveFile(r.Context(), info.Identity, part, u.UploadDir, !u.KeepFileName, info.Reserved)
+	f.Close()
+	t.logUploads(r, []*UploadResult{newUploadResult(part, uploadedFile, err)})
+	if err != nil {
+		t.ErrorJson(w, err)
+		return
+	}
+	if u.OnComplete != nil {
+		u.OnComplete(r, uploadedFile)
+	}
+	w.WriteHeader(status)
+}
+
+// RemoveExpired deletes the partial data of every expired upload. Run it
+// regularly, e.g. from a time.Ticker, so abandoned uploads do not fill the
+// disk.
+func (u *ResumableUploads) RemoveExpired() error {
+	entries, err := os.ReadDir(u.partialDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".info") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".info")
+		unlock := u.lock(id)
+		if u.expired(id) {
+			if info, err := u.info(id); err == nil {
+				u.discard(id, info)
+			} else {
+				u.remove(id)
+			}
+		}
+		unlock()
+	}
+	return nil
+}
+
+// lock takes the lock of the upload id and returns the function that
+// releases it. Once the upload is gone the lock is dropped as well; requests
+// still waiting for it then find no upload and fail.
+func (u *ResumableUploads) lock(id string) func() {
+	lock, _ := u.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	return func() {
+		if !u.exists(id) {
+			u.locks.Delete(id)
+		}
+		mu.Unlock()
+	}
+}
+
+func (u *ResumableUploads) exists(id string) bool {
+	_, err := os.Stat(u.infoPath(id))
+	return err == nil
+}
+
+func (u *ResumableUploads) expiry() time.Duration {
+	if u.Expiry > 0 {
+		return u.Expiry
+	}
+	return 24 * time.Hour
+}
+
+func (u *ResumableUploads) clock() time.Time {
+	if u.now != nil {
+		return u.now()
+	}
+	return time.Now()
+}
+
+// expiresAt returns when the upload id expires, counting from its last write.
+func (u *ResumableUploads) expiresAt(id string) (time.Time, error) {
+	fi, err := os.Stat(u.dataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		fi, err = os.Stat(u.infoPath(id))
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime().Add(u.expiry()), nil
+}
+
+func (u *ResumableUploads) expired(id string) bool {
+	expires, err := u.expiresAt(id)
+	return err == nil && !u.clock().Before(expires)
+}
+
+func (u *ResumableUploads) setExpires(w http.ResponseWriter, id string) {
+	if expires, err := u.expiresAt(id); err == nil {
+		w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (u *ResumableUploads) tools() *Tools {
+	if u.Tools == nil {
+		return &Tools{}
+	}
+	return u.Tools
+}
+
+func (u *ResumableUploads) partialDir() string {
+	if u.PartialDir != "" {
+		return u.PartialDir
+	}
+	return filepath.Join(u.UploadDir, ".tus")
+}
+
+func (u *ResumableUploads) dataPath(id string) string {
+	return filepath.Join(u.partialDir(), id+".bin")
+}
+
+func (u *ResumableUploads) infoPath(id string) string {
+	return filepath.Join(u.partialDir(), id+".info")
+}
+
+func (u *ResumableUploads) info(id string) (*tusInfo, error) {
+	data, err := os.ReadFile(u.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var info tusInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (u *ResumableUploads) writeInfo(id string, info *tusInfo) error {
+	if err := u.tools().CreateDirIfNotExist(u.partialDir()); err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(u.infoPath(id), data, 0644)
+}
+
+func (u *ResumableUploads) offset(id string) (int64, error) {
+	fi, err := os.Stat(u.dataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (u *ResumableUploads) remove(id string) {
+	os.Remove(u.dataPath(id))
+	os.Remove(u.infoPath(id))
+}
+
+// discard removes an unfinished upload and gives back its reserved quota.
+func (u *ResumableUploads) discard(id string, info *tusInfo) {
+	u.remove(id)
+	u.tools().releaseUpfront(info.Identity, info.Reserved)
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated pairs
+// of a key and an optional base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	if header == "" {
+		return nil, nil
+	}
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,232 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func tusRequest(method, target string, body io.Reader, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+func TestResumableUploads(t *testing.T) {
+	storage := NewMemoryStorage()
+	var completed *UploadedFile
+	handler := &ResumableUploads{
+		Tools:      &Tools{Storage: storage, AllowedFileType: []string{"image/png"}},
+		UploadDir:  "uploads",
+		PartialDir: "./testdata/tus",
+		BasePath:   "/files/",
+		OnComplete: func(r *http.Request, f *UploadedFile) { completed = f },
+	}
+	defer os.RemoveAll("./testdata/tus")
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 32)))
+	data := buf.Bytes()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodOptions, "/files/", nil))
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Tus-Version") != "1.0.0" {
+		t.Errorf("unexpected OPTIONS response %d %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/files/", nil))
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 without Tus-Resumable but got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("photo.png")) + ",public",
+	}))
+	location := rr.Header().Get("Location")
+	if rr.Code != http.StatusCreated || location == "" {
+		t.Fatalf("unexpected create response %d %q", rr.Code, location)
+	}
+
+	half := len(data) / 2
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, location, bytes.NewReader(data[:half]), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Errorf("unexpected first PATCH response %d %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodHead, location, nil, nil))
+	if rr.Header().Get("Upload-Offset") != strconv.Itoa(half) || rr.Header().Get("Upload-Length") != strconv.Itoa(len(data)) {
+		t.Errorf("unexpected HEAD response %v", rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, location, bytes.NewReader(data[half:]), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a wrong offset but got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, location, bytes.NewReader(data[half:]), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(half),
+	}))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("unexpected final PATCH response %d %s", rr.Code, rr.Body)
+	}
+	if completed == nil || completed.OriginalFileName != "photo.png" || completed.FileSize != int64(len(data)) {
+		t.Fatalf("unexpected completed upload %+v", completed)
+	}
+	rc, _, err := storage.Get(context.Background(), completed.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := io.ReadAll(rc)
+	if !bytes.Equal(stored, data) {
+		t.Error("stored file does not match the upload")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodHead, location, nil, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected finished upload to be gone but got %d", rr.Code)
+	}
+	if entries, _ := os.ReadDir("./testdata/tus"); len(entries) != 0 {
+		t.Errorf("expected partial data to be removed but found %d files", len(entries))
+	}
+}
+
+func TestResumableUploads_Rejected(t *testing.T) {
+	handler := &ResumableUploads{
+		Tools:      &Tools{Storage: NewMemoryStorage(), AllowedFileType: []string{"image/png"}, MaxFileSize: 1024},
+		UploadDir:  "uploads",
+		PartialDir: "./testdata/tus",
+		BasePath:   "/files/",
+	}
+	defer os.RemoveAll("./testdata/tus")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "2048"}))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an upload over MaxFileSize but got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "5"}))
+	location := rr.Header().Get("Location")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, location, bytes.NewReader([]byte("hello")), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a text upload but got %d", rr.Code)
+	}
+}
+
+func TestResumableUploads_Locks(t *testing.T) {
+	handler := &ResumableUploads{
+		Tools:      &Tools{Storage: NewMemoryStorage()},
+		UploadDir:  "uploads",
+		PartialDir: "./testdata/tus",
+		BasePath:   "/files/",
+	}
+	defer os.RemoveAll("./testdata/tus")
+	countLocks := func() int {
+		n := 0
+		handler.locks.Range(func(key, value interface{}) bool { n++; return true })
+		return n
+	}
+
+	for i := 0; i < 100; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, tusRequest(http.MethodHead, fmt.Sprintf("/files/%032x", i), nil, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for an unknown upload but got %d", rr.Code)
+		}
+	}
+	if n := countLocks(); n != 0 {
+		t.Errorf("expected no locks for unknown uploads but got %d", n)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "5"}))
+	location := rr.Header().Get("Location")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodPatch, location, bytes.NewReader([]byte("hello")), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("unexpected PATCH response %d %s", rr.Code, rr.Body)
+	}
+	if n := countLocks(); n != 0 {
+		t.Errorf("expected the lock to be dropped after the upload finished but got %d", n)
+	}
+}
+
+func TestResumableUploads_Expiry(t *testing.T) {
+	handler := &ResumableUploads{
+		Tools:      &Tools{Storage: NewMemoryStorage()},
+		UploadDir:  "uploads",
+		PartialDir: "./testdata/tus",
+		BasePath:   "/files/",
+		Expiry:     time.Hour,
+	}
+	defer os.RemoveAll("./testdata/tus")
+
+	create := func() string {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, tusRequest(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "10"}))
+		expires, err := http.ParseTime(rr.Header().Get("Upload-Expires"))
+		if err != nil || expires.Before(time.Now().Add(59*time.Minute)) {
+			t.Errorf("unexpected Upload-Expires %q", rr.Header().Get("Upload-Expires"))
+		}
+		return rr.Header().Get("Location")
+	}
+	first, second := create(), create()
+
+	handler.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodHead, first, nil, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an expired upload but got %d", rr.Code)
+	}
+	if entries, _ := os.ReadDir("./testdata/tus"); len(entries) != 1 {
+		t.Errorf("expected only the second upload to be left but found %d files", len(entries))
+	}
+
+	if err := handler.RemoveExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir("./testdata/tus"); len(entries) != 0 {
+		t.Errorf("expected expired uploads to be removed but found %d files", len(entries))
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest(http.MethodHead, second, nil, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a removed upload but got %d", rr.Code)
+	}
+}
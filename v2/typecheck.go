@@ -0,0 +1,198 @@
+package toolkit
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// TypeMismatchPolicy decides what happens when the extension or Content-Type
+// a client declares for an uploaded file disagrees with its content.
+type TypeMismatchPolicy int
+
+const (
+	// TypeMismatchIgnore does not compare declared and detected types.
+	TypeMismatchIgnore TypeMismatchPolicy = iota
+	// TypeMismatchReject rejects the file with ErrFileTypeMismatch.
+	TypeMismatchReject
+	// TypeMismatchRewrite trusts the content and stores the file under the
+	// extension of its detected type.
+	TypeMismatchRewrite
+)
+
+// typeAliases maps Content-Type values browsers and operating systems send
+// to the names the sniffer uses.
+var typeAliases = map[string]string{
+	"application/gzip":             "application/x-gzip",
+	"application/vnd.rar":          "application/x-rar-compressed",
+	"application/x-pdf":            "application/pdf",
+	"application/x-zip":            "application/zip",
+	"application/x-zip-compressed": "application/zip",
+	"application/xml":              "text/xml",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/vnd.wave":               "audio/wave",
+	"audio/wav":                    "audio/wave",
+	"audio/x-aiff":                 "audio/aiff",
+	"audio/x-flac":                 "audio/flac",
+	"audio/x-m4a":                  "audio/mp4",
+	"audio/x-wav":                  "audio/wave",
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/vnd.microsoft.icon":     "image/x-icon",
+	"image/x-ms-bmp":               "image/bmp",
+	"image/x-png":                  "image/png",
+	"video/x-msvideo":              "video/avi",
+}
+
+// refinedTypes lists, for detected types that are only a container or an
+// encoding, the more specific types a file of that kind may really be.
+// Types that browsers run as active content, such as HTML, SVG and
+// JavaScript, are left out on purpose.
+var refinedTypes = map[string][]string{
+	"application/zip": {
+		"application/epub+zip",
+		"application/java-archive",
+		"application/vnd.android.package-archive",
+		"application/vnd.oasis.opendocument.formula",
+		"application/vnd.oasis.opendocument.graphics",
+		"application/vnd.oasis.opendocument.presentation",
+		"application/vnd.oasis.opendocument.spreadsheet",
+		"application/vnd.oasis.opendocument.text",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	},
+	"application/x-ole-storage": {
+		"application/msword",
+		"application/vnd.ms-excel",
+		"application/vnd.ms-outlook",
+		"application/vnd.ms-powerpoint",
+	},
+	"text/plain": {
+		"application/json",
+		"application/yaml",
+		"text/calendar",
+		"text/css",
+		"text/csv",
+		"text/markdown",
+		"text/tab-separated-values",
+		"text/vcard",
+	},
+	"application/ogg": {"audio/ogg", "video/ogg"},
+	"video/mp4":       {"audio/mp4"},
+	"video/webm":      {"audio/webm"},
+}
+
+// checkFileType compares the extension of fileName and the Content-Type
+// declared for the file with fileType, its detected type, following
+// TypeMismatch. It returns the name to store the file under.
+func (t *Tools) checkFileType(fileName, declaredType, fileType string) (string, error) {
+	if t.TypeMismatch == TypeMismatchIgnore {
+		return fileName, nil
+	}
+	detected := mediaType(fileType)
+	accepted := append([]string{detected}, refinedTypes[detected]...)
+
+	ext := strings.ToLower(filepath.Ext(sanitizeFileName(fileName)))
+	extOK := ext == "" || t.extensionMatches(ext, accepted)
+	declared := normalizeType(declaredType)
+	typeOK := declared == "" || declared == "application/octet-stream" || t.typeMatches(declared, accepted)
+	if extOK && typeOK {
+		return fileName, nil
+	}
+
+	if t.TypeMismatch == TypeMismatchReject {
+		if !extOK {
+			return "", &detailedError{ErrFileTypeMismatch, fmt.Sprintf("extension %s does not match the content type %s", ext, detected)}
+		}
+		return "", &detailedError{ErrFileTypeMismatch, fmt.Sprintf("declared type %s does not match the content type %s", declared, detected)}
+	}
+	newExt := ""
+	if exts := t.sniffer().Extensions(fileType); len(exts) > 0 {
+		newExt = exts[0]
+	}
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + newExt, nil
+}
+
+// extensionMatches reports whether ext is used by one of types. For content
+// the sniffer does not recognise, any extension it does not know either is
+// accepted.
+func (t *Tools) extensionMatches(ext string, types []string) bool {
+	sniffer := t.sniffer()
+	if types[0] == "application/octet-stream" {
+		return !knownExtension(sniffer, ext)
+	}
+	for _, typ := range types {
+		for _, e := range sniffer.Extensions(typ) {
+			if strings.EqualFold(e, ext) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeMatches reports whether declared is one of types. For content the
+// sniffer does not recognise, any type it does not know either is accepted.
+func (t *Tools) typeMatches(declared string, types []string) bool {
+	if types[0] == "application/octet-stream" {
+		return len(t.sniffer().Extensions(declared)) == 0
+	}
+	for _, typ := range types {
+		if declared == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// serverExtensions are extensions web servers commonly run as code. They
+// never pass as the extension of unrecognised content.
+var serverExtensions = map[string]bool{
+	".asp": true, ".aspx": true, ".ashx": true, ".asmx": true, ".cer": true,
+	".cgi": true, ".jsp": true, ".jspx": true, ".php": true, ".php3": true,
+	".php4": true, ".php5": true, ".phar": true, ".phtml": true, ".pl": true,
+	".py": true, ".rb": true, ".sh": true, ".shtml": true,
+}
+
+// knownExtension reports whether ext belongs to a type sniffer knows, or is
+// run as code by web servers.
+func knownExtension(sniffer ContentSniffer, ext string) bool {
+	if serverExtensions[ext] {
+		return true
+	}
+	if s, ok := sniffer.(*SignatureSniffer); ok {
+		for _, sig := range s.Signatures {
+			for _, e := range sig.Extensions {
+				if strings.EqualFold(e, ext) {
+					return true
+				}
+			}
+		}
+	}
+	for _, exts := range typeExtensions {
+		for _, e := range exts {
+			if e == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mediaType strips the parameters from a MIME type and lowercases it.
+func mediaType(mimeType string) string {
+	if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return parsed
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+func normalizeType(mimeType string) string {
+	mt := mediaType(mimeType)
+	if alias, ok := typeAliases[mt]; ok {
+		return alias
+	}
+	return mt
+}
@@ -0,0 +1,98 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+func newTypedUploadRequest(t *testing.T, fileName, contentType string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+	request := httptest.NewRequest("POST", "/", body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+var (
+	htmlContent = []byte("<html><body><script>alert(1)</script></body></html>")
+	pdfContent  = []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	pngContent  = []byte("\x89PNG\x0d\x0a\x1a\x0a\x00\x00\x00\x0dIHDR")
+	svgContent  = []byte(`<?xml version="1.0"?><!-- logo --><svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`)
+	binContent  = []byte("\x00\x01\x02\x03\x04\x05\x06\x07\xff\xfe")
+)
+
+var typeMismatchTests = []struct {
+	name          string
+	policy        TypeMismatchPolicy
+	fileName      string
+	contentType   string
+	content       []byte
+	expectedName  string
+	errorExpected bool
+}{
+	{name: "ignored", policy: TypeMismatchIgnore, fileName: "report.pdf", contentType: "application/pdf", content: htmlContent, expectedName: "report.pdf"},
+	{name: "consistent", policy: TypeMismatchReject, fileName: "report.pdf", contentType: "application/pdf", content: pdfContent, expectedName: "report.pdf"},
+	{name: "html as pdf", policy: TypeMismatchReject, fileName: "report.pdf", contentType: "application/pdf", content: htmlContent, errorExpected: true},
+	{name: "html with the right name", policy: TypeMismatchReject, fileName: "page.html", contentType: "application/pdf", content: htmlContent, errorExpected: true},
+	{name: "generic declared type", policy: TypeMismatchReject, fileName: "logo.png", contentType: "application/octet-stream", content: pngContent, expectedName: "logo.png"},
+	{name: "aliased declared type", policy: TypeMismatchReject, fileName: "photo.png", contentType: "image/x-png", content: pngContent, expectedName: "photo.png"},
+	{name: "wrong declared type", policy: TypeMismatchReject, fileName: "scan", contentType: "image/png", content: pdfContent, errorExpected: true},
+	{name: "second extension", policy: TypeMismatchReject, fileName: "notes.markdown", contentType: "text/markdown", content: []byte("# notes"), expectedName: "notes.markdown"},
+	{name: "csv sniffed as text", policy: TypeMismatchReject, fileName: "data.csv", contentType: "text/csv", content: []byte("a,b\n1,2\n"), expectedName: "data.csv"},
+	{name: "svg sniffed as svg", policy: TypeMismatchReject, fileName: "logo.svg", contentType: "image/svg+xml", content: svgContent, expectedName: "logo.svg"},
+	{name: "svg as text", policy: TypeMismatchReject, fileName: "notes.txt", contentType: "text/plain", content: svgContent, errorExpected: true},
+	{name: "unknown binary", policy: TypeMismatchReject, fileName: "model.onnx", content: binContent, expectedName: "model.onnx"},
+	{name: "binary as script", policy: TypeMismatchReject, fileName: "shell.php", content: binContent, errorExpected: true},
+	{name: "binary as image", policy: TypeMismatchReject, fileName: "photo.jpg", content: binContent, errorExpected: true},
+	{name: "rewritten", policy: TypeMismatchRewrite, fileName: "report.pdf", contentType: "application/pdf", content: htmlContent, expectedName: "report.html"},
+	{name: "rewritten without extension", policy: TypeMismatchRewrite, fileName: "shell.php", content: binContent, expectedName: "shell"},
+	{name: "no extension", policy: TypeMismatchRewrite, fileName: "scan", contentType: "application/pdf", content: pdfContent, expectedName: "scan"},
+}
+
+func TestTools_UploadTypeMismatch(t *testing.T) {
+	for _, e := range typeMismatchTests {
+		testTools := Tools{Storage: NewMemoryStorage(), TypeMismatch: e.policy}
+		uploadedFile, err := testTools.UploadOneFile(newTypedUploadRequest(t, e.fileName, e.contentType, e.content), "uploads", false)
+		if e.errorExpected {
+			if !errors.Is(err, ErrFileTypeMismatch) {
+				t.Errorf("%s: expected ErrFileTypeMismatch but got %v", e.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if uploadedFile.NewFileName != e.expectedName {
+			t.Errorf("%s: expected the file to be stored as %q but got %q", e.name, e.expectedName, uploadedFile.NewFileName)
+		}
+	}
+}
+
+func TestTools_UploadTypeMismatchRenamed(t *testing.T) {
+	testTools := Tools{Storage: NewMemoryStorage(), TypeMismatch: TypeMismatchRewrite}
+	uploadedFile, err := testTools.UploadOneFile(newTypedUploadRequest(t, "report.pdf", "application/pdf", htmlContent), "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := uploadedFile.NewFileName[len(uploadedFile.NewFileName)-5:]; ext != ".html" {
+		t.Errorf("expected a random name ending in .html but got %q", uploadedFile.NewFileName)
+	}
+}
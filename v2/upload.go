@@ -0,0 +1,376 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"path/filepath"
+)
+
+type UploadStatus string
+
+const (
+	UploadSaved    UploadStatus = "saved"
+	UploadRejected UploadStatus = "rejected"
+	UploadFailed   UploadStatus = "failed"
+)
+
+// UploadResult reports what happened to a single file of an upload. Err is
+// set for rejected and failed files.
+type UploadResult struct {
+	FieldName        string
+	OriginalFileName string
+	Status           UploadStatus
+	File             *UploadedFile
+	Err              error
+}
+
+// UploadFilesWithResults saves every file in r it can and reports the outcome
+// for each one. The returned error is only set when the request itself could
+// not be read; results for the files handled until then are still returned.
+func (t *Tools) UploadFilesWithResults(r *http.Request, uploadDir string, rename ...bool) ([]*UploadResult, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+	return t.upload(r, uploadDir, renameFile, false)
+}
+
+// upload saves the files in r, stopping at the first file that is not saved
+// if stopOnError is set. With AllOrNothing every saved file is removed again
+// when any file fails.
+func (t *Tools) upload(r *http.Request, uploadDir string, renameFile, stopOnError bool) ([]*UploadResult, error) {
+	nextPart, err := t.fileParts(r)
+	if err != nil {
+		return nil, err
+	}
+	identity := t.identity(r)
+	var results []*UploadResult
+	failed := false
+	for {
+		part, err := nextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if t.AllOrNothing {
+				t.rollback(r.Context(), identity, results)
+			}
+			t.logUploads(r, results)
+			t.logger().Error("upload request failed", append(requestAttrs(r), "error", err, "error_kind", errorKind(err), "status", ErrorStatus(err))...)
+			return results, err
+		}
+		uploadedFile, err := t.saveFile(r.Context(), identity, part, uploadDir, renameFile, Usage{})
+		part.Close()
+		if err != nil {
+			failed = true
+		}
+		results = append(results, newUploadResult(part, uploadedFile, err))
+		if err != nil && stopOnError {
+			break
+		}
+	}
+	if failed && t.AllOrNothing {
+		t.rollback(r.Context(), identity, results)
+	}
+	t.logUploads(r, results)
+	return results, nil
+}
+
+func (t *Tools) logUploads(r *http.Request, results []*UploadResult) {
+	for _, result := range results {
+		attrs := append(requestAttrs(r), "field", result.FieldName, "file_name", result.OriginalFileName, "status", string(result.Status))
+		switch result.Status {
+		case UploadSaved:
+			t.logger().Info("upload saved", append(attrs, "key", result.File.Key, "size", result.File.FileSize)...)
+		case UploadRejected:
+			t.logger().Warn("upload rejected", append(attrs, "error", result.Err, "error_kind", errorKind(result.Err))...)
+		default:
+			t.logger().Error("upload failed", append(attrs, "error", result.Err, "error_kind", errorKind(result.Err))...)
+		}
+	}
+}
+
+func newUploadResult(part *filePart, uploadedFile *UploadedFile, err error) *UploadResult {
+	result := &UploadResult{
+		FieldName:        part.FieldName,
+		OriginalFileName: part.FileName,
+		Status:           UploadSaved,
+		File:             uploadedFile,
+		Err:              err,
+	}
+	if err != nil {
+		result.Status = UploadFailed
+		if isRejection(err) {
+			result.Status = UploadRejected
+		}
+	}
+	return result
+}
+
+// maxFileSize returns MaxFileSize, defaulting to 1 GiB.
+func (t *Tools) maxFileSize() int64 {
+	if t.MaxFileSize == 0 {
+		return 1024 * 1024 * 1024
+	}
+	return int64(t.MaxFileSize)
+}
+
+// rollback removes every saved file in results and gives back its quota.
+func (t *Tools) rollback(ctx context.Context, identity string, results []*UploadResult) {
+	for _, result := range results {
+		if result.Status != UploadSaved {
+			continue
+		}
+		t.deleteUploadedFile(ctx, result.File)
+		t.ReleaseQuota(ctx, identity, result.File)
+		result.Status = UploadFailed
+		result.Err = ErrUploadRolledBack
+		result.File = nil
+	}
+}
+
+// deleteUploadedFile removes a saved upload and its renditions from Storage.
+// Deduplicated content is left alone even when this upload stored it, as
+// other uploads may have been handed the same key in the meantime.
+func (t *Tools) deleteUploadedFile(ctx context.Context, f *UploadedFile) {
+	if t.Deduplicate {
+		return
+	}
+	t.storage().Delete(ctx, f.Key)
+	for _, rendition := range f.Renditions {
+		t.storage().Delete(ctx, rendition.Key)
+	}
+}
+
+// isRejection reports whether err means the file was refused by validation
+// rather than lost to an I/O error.
+func isRejection(err error) bool {
+	return errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrFileTypeNotAllowed) || errors.Is(err, ErrDigestMismatch) ||
+		errors.Is(err, ErrInvalidFileName) || errors.Is(err, ErrFileExists) || errors.Is(err, ErrQuotaExceeded) ||
+		errors.Is(err, ErrFileTypeMismatch) || errors.Is(err, ErrImageTooLarge) || errors.Is(err, ErrInvalidImage)
+}
+
+// filePart is a single file taken from a multipart request, either from a
+// parsed form or straight off the wire when streaming.
+type filePart struct {
+	io.ReadCloser
+	FieldName string
+	FileName  string
+	Header    textproto.MIMEHeader
+}
+
+// fileParts returns an iterator over the files in r. It returns io.EOF once
+// every file has been handed out.
+func (t *Tools) fileParts(r *http.Request) (func() (*filePart, error), error) {
+	if t.StreamUploads {
+		return t.streamParts(r)
+	}
+
+	//Validate file size is within permitted value
+	err := r.ParseMultipartForm(t.maxFileSize())
+	if err != nil {
+		return nil, ErrFileTooLarge
+	}
+	var hdrs []*multipart.FileHeader
+	var fields []string
+	for field, fHeaders := range r.MultipartForm.File {
+		for _, hdr := range fHeaders {
+			hdrs = append(hdrs, hdr)
+			fields = append(fields, field)
+		}
+	}
+	return func() (*filePart, error) {
+		if len(hdrs) == 0 {
+			return nil, io.EOF
+		}
+		hdr, field := hdrs[0], fields[0]
+		hdrs, fields = hdrs[1:], fields[1:]
+		infile, err := hdr.Open()
+		if err != nil {
+			return nil, err
+		}
+		return &filePart{ReadCloser: infile, FieldName: field, FileName: hdr.Filename, Header: hdr.Header}, nil
+	}, nil
+}
+
+// streamParts reads file parts directly from the request body, so nothing is
+// buffered in memory or spilled to temporary files before it is validated.
+func (t *Tools) streamParts(r *http.Request) (func() (*filePart, error), error) {
+	if t.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, int64(t.MaxUploadSize))
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	return func() (*filePart, error) {
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				return nil, bodyTooLarge(err)
+			}
+			// skip ordinary form fields
+			if part.FileName() == "" {
+				part.Close()
+				continue
+			}
+			return &filePart{ReadCloser: part, FieldName: part.FormName(), FileName: part.FileName(), Header: part.Header}, nil
+		}
+	}, nil
+}
+
+// bodyTooLarge turns the error of the MaxUploadSize limit set by streamParts
+// into ErrBodyTooLarge, whether it trips between parts or inside one.
+func bodyTooLarge(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return &detailedError{ErrBodyTooLarge, fmt.Sprintf("upload must not be larger than %d bytes", maxBytesError.Limit)}
+	}
+	return err
+}
+
+// partReader reads a file part, reporting the upload limit as bodyTooLarge
+// does.
+type partReader struct {
+	r io.Reader
+}
+
+func (p partReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	return n, bodyTooLarge(err)
+}
+
+// saveFile validates a single part and copies it into uploadDir, counting it
+// against the quota of identity. reserved is quota already set aside for the
+// file; it is settled with the rest, or given back when the file fails.
+func (t *Tools) saveFile(ctx context.Context, identity string, part *filePart, uploadDir string, renameFile bool, reserved Usage) (*UploadedFile, error) {
+	var uploadedFile UploadedFile
+	var quota *quotaReader
+	defer func() {
+		// once quota exists it owns the reservation
+		if quota == nil {
+			t.releaseUpfront(identity, reserved)
+		}
+	}()
+	infile := &maxBytesReader{r: partReader{part}, n: t.maxFileSize(), err: ErrFileTooLarge}
+
+	sniffer := t.sniffer()
+	buff := make([]byte, sniffer.SniffLen())
+	n, err := io.ReadFull(infile, buff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	buff = buff[:n]
+	fileType := sniffer.Sniff(buff)
+	//validate file type is permitted
+	if !t.fileTypeAllowed(fileType) {
+		return nil, ErrFileTypeNotAllowed
+	}
+	fileName, err := t.checkFileType(part.FileName, part.Header.Get("Content-Type"), fileType)
+	if err != nil {
+		return nil, err
+	}
+
+	checks, err := partDigests(part.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadedFile.OriginalFileName = part.FileName
+	var src io.Reader = io.MultiReader(bytes.NewReader(buff), infile)
+	if len(checks) > 0 {
+		src = &digestReader{r: src, checks: checks}
+	}
+	var img image.Image
+	if t.Images.enabled() && isProcessableImage(fileType) {
+		src, img, err = t.processImage(src, fileType, &uploadedFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	quota, err = t.reserveQuota(ctx, identity, src, reserved)
+	if err != nil {
+		return nil, err
+	}
+	if quota != nil {
+		src = quota
+	}
+	info, err := t.storeFile(ctx, &uploadedFile, fileName, uploadDir, renameFile, src, fileType)
+	if err != nil {
+		if quota != nil {
+			quota.settle(Usage{})
+		}
+		return nil, err
+	}
+	uploadedFile.FileSize = info.Size
+	uploadedFile.Key = info.Key
+	uploadedFile.Bucket = info.Bucket
+	uploadedFile.ETag = info.ETag
+	if img != nil && len(t.Images.Renditions) > 0 {
+		if err := t.storeRenditions(ctx, &uploadedFile, img, fileType); err != nil {
+			t.deleteUploadedFile(ctx, &uploadedFile)
+			if quota != nil {
+				quota.settle(Usage{})
+			}
+			return nil, err
+		}
+	}
+	if quota != nil {
+		quota.settle(fileUsage(&uploadedFile))
+	}
+	return &uploadedFile, nil
+}
+
+// storeFile writes src to Storage under the name the upload settings call
+// for, filling in the name fields of uploadedFile.
+func (t *Tools) storeFile(ctx context.Context, uploadedFile *UploadedFile, fileName, uploadDir string, renameFile bool, src io.Reader, contentType string) (*ObjectInfo, error) {
+	ext := filepath.Ext(sanitizeFileName(fileName))
+	if t.Deduplicate {
+		return t.putDeduplicated(ctx, uploadedFile, uploadDir, ext, src, contentType)
+	}
+	var key string
+	if renameFile {
+		uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), ext)
+		key = path.Join(filepath.ToSlash(uploadDir), uploadedFile.NewFileName)
+	} else {
+		var err error
+		key, uploadedFile.NewFileName, err = t.originalFileKey(ctx, uploadDir, fileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.storage().Put(ctx, key, src, contentType)
+}
+
+// maxBytesReader reads at most n bytes from r and fails with err if r holds
+// more than that.
+type maxBytesReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *maxBytesReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, err
+	}
+	n = int(l.n)
+	l.n = -1
+	return n, l.err
+}
@@ -0,0 +1,66 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var uploadResultTests = []struct {
+	name           string
+	allOrNothing   bool
+	expectedStatus []UploadStatus
+	expectedStored int
+}{
+	{name: "partial", allOrNothing: false, expectedStatus: []UploadStatus{UploadSaved, UploadRejected, UploadSaved}, expectedStored: 2},
+	{name: "all or nothing", allOrNothing: true, expectedStatus: []UploadStatus{UploadFailed, UploadRejected, UploadFailed}, expectedStored: 0},
+}
+
+func TestTools_UploadFilesWithResults(t *testing.T) {
+	for _, e := range uploadResultTests {
+		storage := NewMemoryStorage()
+		testTools := Tools{
+			Storage:         storage,
+			StreamUploads:   true,
+			AllowedFileType: []string{"image/png"},
+			AllOrNothing:    e.allOrNothing,
+		}
+		results, err := testTools.UploadFilesWithResults(newUploadRequest(t, "a.png", "b.txt", "c.png"), "uploads")
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if len(results) != len(e.expectedStatus) {
+			t.Fatalf("%s: expected %d results but got %d", e.name, len(e.expectedStatus), len(results))
+		}
+		for i, result := range results {
+			if result.Status != e.expectedStatus[i] {
+				t.Errorf("%s: %s: expected status %s but got %s", e.name, result.OriginalFileName, e.expectedStatus[i], result.Status)
+			}
+			if result.FieldName != "file" {
+				t.Errorf("%s: wrong field name %q", e.name, result.FieldName)
+			}
+		}
+		if !errors.Is(results[1].Err, ErrFileTypeNotAllowed) {
+			t.Errorf("%s: expected ErrFileTypeNotAllowed but got %v", e.name, results[1].Err)
+		}
+		if e.allOrNothing && !errors.Is(results[0].Err, ErrUploadRolledBack) {
+			t.Errorf("%s: expected ErrUploadRolledBack but got %v", e.name, results[0].Err)
+		}
+		objects, _ := storage.List(context.Background(), "uploads/")
+		if len(objects) != e.expectedStored {
+			t.Errorf("%s: expected %d stored files but got %d", e.name, e.expectedStored, len(objects))
+		}
+	}
+}
+
+func TestTools_UploadFilesAllOrNothing(t *testing.T) {
+	storage := NewMemoryStorage()
+	testTools := Tools{Storage: storage, StreamUploads: true, AllowedFileType: []string{"image/png"}, AllOrNothing: true}
+	_, err := testTools.UploadFiles(newUploadRequest(t, "a.png", "b.txt"), "uploads")
+	if !errors.Is(err, ErrFileTypeNotAllowed) {
+		t.Errorf("expected ErrFileTypeNotAllowed but got %v", err)
+	}
+	if objects, _ := storage.List(context.Background(), "uploads/"); len(objects) != 0 {
+		t.Errorf("expected earlier files to be removed but found %d", len(objects))
+	}
+}
@@ -0,0 +1,113 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookSigner_RoundTrip(t *testing.T) {
+	var testTools Tools
+	receiver := &WebhookSigner{Secret: []byte("shh")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := testTools.ReadJSON(w, r, &event, WithSignatureVerification(receiver)); err != nil {
+			testTools.ErrorJson(w, err)
+			return
+		}
+		if event.Type != "created" {
+			t.Errorf("unexpected event %+v", event)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	for _, secret := range []string{"shh", "wrong"} {
+		res, err := testTools.PushJSON(context.Background(), srv.URL, map[string]string{"type": "created"},
+			WithSigner(&WebhookSigner{Secret: []byte(secret)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		expected := http.StatusNoContent
+		if secret == "wrong" {
+			expected = http.StatusUnauthorized
+		}
+		if res.StatusCode != expected {
+			t.Errorf("%s: expected %d but got %d", secret, expected, res.StatusCode)
+		}
+	}
+}
+
+var webhookVerifyTests = []struct {
+	name     string
+	age      time.Duration
+	body     string
+	sig      func(s *WebhookSigner, ts int64) string
+	expected error
+}{
+	{name: "valid", body: `{"a":1}`, expected: nil},
+	{name: "tampered body", body: `{"a":2}`, expected: ErrInvalidSignature},
+	{name: "too old", age: 10 * time.Minute, body: `{"a":1}`, expected: ErrSignatureExpired},
+	{name: "from the future", age: -10 * time.Minute, body: `{"a":1}`, expected: ErrSignatureExpired},
+	{name: "missing signature", body: `{"a":1}`, sig: func(*WebhookSigner, int64) string { return "" }, expected: ErrInvalidSignature},
+	{name: "rotated secrets", body: `{"a":1}`, sig: func(s *WebhookSigner, ts int64) string {
+		old := &WebhookSigner{Secret: []byte("old")}
+		return old.Signature(ts, []byte(`{"a":1}`)) + ", " + s.Signature(ts, []byte(`{"a":1}`))
+	}, expected: nil},
+}
+
+func TestWebhookSigner_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := &WebhookSigner{Secret: []byte("shh"), now: func() time.Time { return now }}
+	for _, e := range webhookVerifyTests {
+		ts := now.Add(-e.age).Unix()
+		header := make(http.Header)
+		header.Set(DefaultTimestampHeader, strconv.FormatInt(ts, 10))
+		if e.sig != nil {
+			header.Set(DefaultSignatureHeader, e.sig(s, ts))
+		} else {
+			header.Set(DefaultSignatureHeader, s.Signature(ts, []byte(`{"a":1}`)))
+		}
+		err := s.Verify(header, []byte(e.body))
+		if !errors.Is(err, e.expected) || (e.expected == nil && err != nil) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+
+	var testTools Tools
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"a":1}`)))
+	var v map[string]int
+	err := testTools.ReadJSON(httptest.NewRecorder(), req, &v, WithSignatureVerification(s))
+	if !errors.Is(err, ErrInvalidSignature) || ErrorStatus(err) != http.StatusUnauthorized {
+		t.Errorf("expected unsigned request to be rejected with 401 but got %v", err)
+	}
+}
+
+func TestWebhookSigner_MissingSecret(t *testing.T) {
+	s := &WebhookSigner{}
+	header := make(http.Header)
+	// anyone can sign with the empty key
+	ts := time.Now().Unix()
+	header.Set(DefaultTimestampHeader, strconv.FormatInt(ts, 10))
+	header.Set(DefaultSignatureHeader, s.Signature(ts, []byte(`{"a":1}`)))
+	if err := s.Verify(header, []byte(`{"a":1}`)); !errors.Is(err, ErrMissingSecret) {
+		t.Errorf("expected ErrMissingSecret but got %v", err)
+	}
+
+	var testTools Tools
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected nothing to be sent without a secret")
+	}))
+	defer srv.Close()
+	if _, err := testTools.PushJSON(context.Background(), srv.URL, "x", WithSigner(s)); !errors.Is(err, ErrMissingSecret) {
+		t.Errorf("expected ErrMissingSecret but got %v", err)
+	}
+}